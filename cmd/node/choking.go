@@ -0,0 +1,188 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	UploadSlots               = 4 // Nodes a file is uploaded to at once, across every file
+	RechokeInterval           = 10 * time.Second
+	OptimisticUnchokeInterval = 30 * time.Second
+	InterestTimeout           = 30 * time.Second // Nodes that stop requesting chunks for this long free their slot
+	ChokedRequestInterval     = InterestTimeout / 3
+)
+
+// A node downloading a file from us
+type upload struct {
+	Address  string
+	FileName string
+}
+
+// Choker decides which nodes are uploaded to. Slots go to the nodes we download the most from (tit-for-tat),
+// except for one, which rotates between the other nodes so they get a chance to prove themselves (optimistic unchoke)
+type Choker struct {
+	interested map[upload]time.Time // Upload -> Last time chunks were requested
+	unchoked   map[upload]struct{}
+
+	optimistic          upload
+	hasOptimistic       bool
+	lastOptimisticCheck time.Time
+
+	sync.Mutex
+}
+
+func NewChoker() *Choker {
+	return &Choker{
+		interested: make(map[upload]time.Time),
+		unchoked:   make(map[upload]struct{}),
+	}
+}
+
+// Records that the node requested chunks of the file, and returns whether it has an upload slot.
+// Nodes get a free slot right away, instead of waiting for the next rechoke
+func (c *Choker) RequestUpload(address string, fileName string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	key := upload{address, fileName}
+	c.interested[key] = time.Now()
+
+	if _, ok := c.unchoked[key]; ok {
+		return true
+	}
+
+	if len(c.unchoked) < UploadSlots {
+		c.unchoked[key] = struct{}{}
+		return true
+	}
+
+	return false
+}
+
+func (c *Choker) NumberOfUnchoked() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.unchoked)
+}
+
+// Reassigns the upload slots given how fast each node uploads to us,
+// and returns the uploads that were choked and unchoked
+func (c *Choker) Rechoke(downloadSpeed func(address string) float64) ([]upload, []upload) {
+	c.Lock()
+	defer c.Unlock()
+
+	candidates := make([]upload, 0, len(c.interested))
+	for key, lastRequest := range c.interested {
+		if time.Since(lastRequest) > InterestTimeout {
+			delete(c.interested, key)
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+
+	speeds := make(map[string]float64)
+	for _, key := range candidates {
+		speed := downloadSpeed(key.Address)
+		if math.IsNaN(speed) {
+			speed = 0 // Nothing was downloaded from the node
+		}
+		speeds[key.Address] = speed
+	}
+
+	// Ties keep their slots, so nodes as fast as each other are not choked and unchoked on every rechoke
+	sort.Slice(candidates, func(i, j int) bool {
+		speedI, speedJ := speeds[candidates[i].Address], speeds[candidates[j].Address]
+		if speedI != speedJ {
+			return speedI > speedJ
+		}
+
+		_, unchokedI := c.unchoked[candidates[i]]
+		_, unchokedJ := c.unchoked[candidates[j]]
+		if unchokedI != unchokedJ {
+			return unchokedI
+		}
+
+		return candidates[i].Address < candidates[j].Address
+	})
+
+	// Keep the optimistic unchoke for a while, so the node has time to upload to us
+	if _, stillInterested := c.interested[c.optimistic]; !stillInterested || time.Since(c.lastOptimisticCheck) > OptimisticUnchokeInterval {
+		c.hasOptimistic = false
+	}
+
+	regularSlots := UploadSlots - 1
+	unchoked := make(map[upload]struct{})
+	others := make([]upload, 0)
+	for _, key := range candidates {
+		if c.hasOptimistic && key == c.optimistic {
+			continue
+		}
+
+		if len(unchoked) < regularSlots {
+			unchoked[key] = struct{}{}
+		} else {
+			others = append(others, key)
+		}
+	}
+
+	if !c.hasOptimistic && len(others) > 0 {
+		c.optimistic = others[rand.Intn(len(others))]
+		c.hasOptimistic = true
+		c.lastOptimisticCheck = time.Now()
+	}
+	if c.hasOptimistic {
+		unchoked[c.optimistic] = struct{}{}
+	}
+
+	choked := make([]upload, 0)
+	for key := range c.unchoked {
+		if _, ok := unchoked[key]; !ok {
+			choked = append(choked, key)
+		}
+	}
+
+	newlyUnchoked := make([]upload, 0)
+	for key := range unchoked {
+		if _, ok := c.unchoked[key]; !ok {
+			newlyUnchoked = append(newlyUnchoked, key)
+		}
+	}
+
+	c.unchoked = unchoked
+
+	return choked, newlyUnchoked
+}
+
+// Periodically reassigns the upload slots, letting nodes know whether they got one or lost theirs
+func (n *Node) rechoke() {
+	choked, unchoked := n.choker.Rechoke(n.nodeStatistics.getAverageDownloadSpeed)
+
+	for _, key := range choked {
+		addr, err := net.ResolveUDPAddr("udp4", key.Address)
+		if err != nil {
+			continue
+		}
+
+		packet := protocol.NewChokePacket(key.FileName)
+		n.srv.EnqueueRequest(&packet, addr)
+	}
+
+	for _, key := range unchoked {
+		addr, err := net.ResolveUDPAddr("udp4", key.Address)
+		if err != nil {
+			continue
+		}
+
+		logger.Info("Unchoking node %s for file %s", key.Address, key.FileName)
+		packet := protocol.NewUnchokePacket(key.FileName)
+		n.srv.EnqueueRequest(&packet, addr)
+	}
+}
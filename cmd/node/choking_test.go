@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+const chokingTestFile = "file.txt"
+
+// Makes the given number of nodes request chunks, returning them from the fastest to the slowest
+// along with how fast each of them uploads to us
+func interestedNodes(c *Choker, numberOfNodes int) ([]upload, func(address string) float64) {
+	nodes := make([]upload, 0, numberOfNodes)
+	speeds := make(map[string]float64)
+	for i := 0; i < numberOfNodes; i++ {
+		key := upload{fmt.Sprintf("10.0.0.%d:9000", i+1), chokingTestFile}
+		c.RequestUpload(key.Address, key.FileName)
+		nodes = append(nodes, key)
+		speeds[key.Address] = float64(numberOfNodes - i)
+	}
+
+	return nodes, func(address string) float64 {
+		if speed, ok := speeds[address]; ok {
+			return speed
+		}
+		return math.NaN()
+	}
+}
+
+func containsUpload(uploads []upload, key upload) bool {
+	for _, u := range uploads {
+		if u == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestChokerTitForTat(t *testing.T) {
+	c := NewChoker()
+	nodes, speeds := interestedNodes(c, 8)
+	c.Rechoke(speeds)
+
+	if c.NumberOfUnchoked() != UploadSlots {
+		t.Fatalf("Expected %d unchoked nodes, got %d", UploadSlots, c.NumberOfUnchoked())
+	}
+
+	// Every slot but the optimistic one goes to the fastest nodes
+	for _, key := range nodes[:UploadSlots-1] {
+		if _, ok := c.unchoked[key]; !ok {
+			t.Errorf("Expected node %s to be unchoked", key.Address)
+		}
+	}
+
+	if !c.hasOptimistic || containsUpload(nodes[:UploadSlots-1], c.optimistic) {
+		t.Errorf("Expected the optimistic unchoke to be one of the slower nodes, got %v", c.optimistic)
+	}
+	if _, ok := c.unchoked[c.optimistic]; !ok {
+		t.Errorf("Expected the optimistic node %s to be unchoked", c.optimistic.Address)
+	}
+}
+
+func TestChokerOptimisticRotation(t *testing.T) {
+	c := NewChoker()
+	_, speeds := interestedNodes(c, 8)
+	c.Rechoke(speeds)
+
+	// Kept until the interval passes
+	optimistic := c.optimistic
+	choked, unchoked := c.Rechoke(speeds)
+	if c.optimistic != optimistic || len(choked) != 0 || len(unchoked) != 0 {
+		t.Fatalf("Expected the optimistic unchoke to be kept, got %v, choked %v and unchoked %v", c.optimistic, choked, unchoked)
+	}
+
+	// Rotates once the interval passes, possibly to the same node again
+	for try := 0; try < 100 && c.optimistic == optimistic; try++ {
+		c.lastOptimisticCheck = time.Now().Add(-OptimisticUnchokeInterval - time.Second)
+		choked, unchoked = c.Rechoke(speeds)
+	}
+
+	if c.optimistic == optimistic {
+		t.Fatalf("Expected the optimistic unchoke to rotate away from %s", optimistic.Address)
+	}
+	if !containsUpload(choked, optimistic) || len(choked) != 1 {
+		t.Errorf("Expected only the previous optimistic node %s to be choked, got %v", optimistic.Address, choked)
+	}
+	if !containsUpload(unchoked, c.optimistic) || len(unchoked) != 1 {
+		t.Errorf("Expected only the new optimistic node %s to be unchoked, got %v", c.optimistic.Address, unchoked)
+	}
+}
+
+func TestChokerInterestExpiry(t *testing.T) {
+	c := NewChoker()
+	nodes, speeds := interestedNodes(c, 2)
+	c.Rechoke(speeds)
+
+	// The fastest node stops requesting chunks
+	c.interested[nodes[0]] = time.Now().Add(-InterestTimeout - time.Second)
+
+	choked, unchoked := c.Rechoke(speeds)
+	if len(choked) != 1 || choked[0] != nodes[0] {
+		t.Errorf("Expected node %s to be choked, got %v", nodes[0].Address, choked)
+	}
+	if len(unchoked) != 0 {
+		t.Errorf("Expected no node to be unchoked, got %v", unchoked)
+	}
+	if _, ok := c.interested[nodes[0]]; ok {
+		t.Errorf("Expected node %s to no longer be interested", nodes[0].Address)
+	}
+}
+
+func TestChokerRechokeDiffs(t *testing.T) {
+	c := NewChoker()
+
+	// Slots are given right away while there are free ones
+	slow := make([]upload, 0, UploadSlots)
+	for i := 0; i < UploadSlots; i++ {
+		key := upload{fmt.Sprintf("10.0.1.%d:9000", i+1), chokingTestFile}
+		if !c.RequestUpload(key.Address, key.FileName) {
+			t.Fatalf("Expected node %s to get a free slot", key.Address)
+		}
+		slow = append(slow, key)
+	}
+
+	fast := upload{"10.0.2.1:9000", chokingTestFile}
+	if c.RequestUpload(fast.Address, fast.FileName) {
+		t.Fatalf("Expected node %s to wait for a rechoke", fast.Address)
+	}
+
+	// The new node uploads to us the fastest, so it takes a slot from one of the others
+	speeds := func(address string) float64 {
+		if address == fast.Address {
+			return 100
+		}
+		return 1
+	}
+
+	choked, unchoked := c.Rechoke(speeds)
+	if len(unchoked) != 1 || unchoked[0] != fast {
+		t.Errorf("Expected only node %s to be unchoked, got %v", fast.Address, unchoked)
+	}
+	if len(choked) != 1 || !containsUpload(slow, choked[0]) {
+		t.Errorf("Expected one of the slower nodes to be choked, got %v", choked)
+	}
+
+	// Nothing changes without new requests or speeds
+	choked, unchoked = c.Rechoke(speeds)
+	if len(choked) != 0 || len(unchoked) != 0 {
+		t.Errorf("Expected no changes, got choked %v and unchoked %v", choked, unchoked)
+	}
+}
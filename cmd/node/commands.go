@@ -2,11 +2,17 @@ package main
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/merkle"
 	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/ratelimit"
+	"PessiTorrent/internal/selection"
+	"PessiTorrent/internal/storage"
 	"PessiTorrent/internal/utils"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 func (n *Node) connect(args []string) error {
@@ -22,13 +28,64 @@ func (n *Node) connect(args []string) error {
 
 // request <file name>
 func (n *Node) requestFile(args []string) error {
-	filename := args[0]
+	return n.queueFile(args[0], 0, n.defaultStrategy, DownloadRange{})
+}
 
-	packet := protocol.NewRequestFilePacket(filename)
-	n.conn.EnqueuePacket(&packet)
+// request-priority <file name> <priority>
+func (n *Node) requestFileWithPriority(args []string) error {
+	priority, err := parsePriority(args[1])
+	if err != nil {
+		return err
+	}
+
+	return n.queueFile(args[0], priority, n.defaultStrategy, DownloadRange{})
+}
+
+// request-range <file name> <bytes | chunks> <first>-<last>
+func (n *Node) requestFileRange(args []string) error {
+	downloadRange, err := ParseDownloadRange(args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	return n.queueFile(args[0], 0, n.defaultStrategy, downloadRange)
+}
+
+func (n *Node) queueFile(fileName string, priority int32, strategy uint8, downloadRange DownloadRange) error {
+	// The size of the file is only known once the tracker answers, but a full disk can be refused right away
+	if free, err := storage.FreeSpace(n.downloadDirectory); err == nil && free == 0 {
+		return fmt.Errorf("no free space left in download directory %s", n.downloadDirectory)
+	}
+
+	return n.enqueueDownload(fileName, priority, strategy, downloadRange)
+}
+
+// stream <file name>
+func (n *Node) streamFile(args []string) error {
+	fileName := args[0]
+	if n.streamAddr == "" {
+		return fmt.Errorf("streaming is disabled, start the node with an HTTP address to stream on")
+	}
+
+	// Chunks are requested in the order they are played, and before those of any other download
+	_, complete := n.completeFile(fileName)
+	if n.isQueued(fileName) {
+		n.queue.Lock()
+		if item, ok := n.queue.get(fileName); ok {
+			item.Priority = StreamPriority
+		}
+		n.queue.Unlock()
+
+		if err := n.setSelection([]string{fileName, selection.StrategyName(selection.StreamingStrategy)}); err != nil {
+			return err
+		}
+	} else if !complete {
+		if err := n.queueFile(fileName, StreamPriority, selection.StreamingStrategy, DownloadRange{}); err != nil {
+			return err
+		}
+	}
 
-	// Data of the file will be updated later, when the tracker responds back
-	n.forDownload.Put(filename, NewForDownloadFile(filename))
+	logger.Info("Streaming file %s on http://%s%s%s", fileName, n.streamAddr, StreamPath, url.PathEscape(fileName))
 
 	return nil
 }
@@ -64,23 +121,41 @@ func (n *Node) publishFile(path string) error {
 	defer file.Close()
 
 	fileName := filepath.Base(path)
+	if !n.sharesPublished(fileName) {
+		return fmt.Errorf("file %s is %s, only seeded files are published", fileName, SharingPolicyName(n.sharingPolicy(fileName, SeedSharing)))
+	}
 
-	fileHash, err := utils.HashFile(file)
+	newHash, err := utils.HashFunction(n.hashAlgorithm)
 	if err != nil {
 		return err
 	}
 
-	chunkHashes := make([][20]byte, 0)
-	fileSize, err := utils.HashFileChunks(file, &chunkHashes)
+	// Taken before hashing, so changes made while hashing are detected later on
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
 
-	newFile := NewFile(fileName, path)
+	fileHash, chunkHashes, fileSize, err := utils.HashFileAndChunks(file, n.hashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	newFile := NewFile(fileName, path, n.hashAlgorithm, fileHash, n.localStorage)
+	newFile.ChunkHashes = chunkHashes
+	newFile.RecordState(info)
+
+	packet := protocol.NewPublishFilePacket(fileName, fileSize, n.hashAlgorithm, fileHash, chunkHashes)
+	if n.merkleTree {
+		// Tracker only stores the root, proofs are sent along with each chunk
+		tree := merkle.NewTree(chunkHashes, newHash)
+		newFile.MerkleTree = &tree
+		packet = protocol.NewMerklePublishFilePacket(fileName, fileSize, n.hashAlgorithm, fileHash, tree.Root())
+	}
+
 	n.pending.Put(fileName, &newFile)
 	logger.Info("Added file %s to pending files", fileName)
 
-	packet := protocol.NewPublishFilePacket(fileName, fileSize, fileHash, chunkHashes)
 	n.conn.EnqueuePacket(&packet)
 	logger.Info("Sent publish file packet to tracker")
 
@@ -128,16 +203,55 @@ func (n *Node) status(_ []string) error {
 		})
 	}
 
+	if n.downloadedFile.Len() != 0 {
+		logger.Info("Downloaded files, not seeded:")
+		n.downloadedFile.ForEach(func(filename string, file *File) {
+			logger.Info("%s at %s", file.FileName, file.Path)
+		})
+	}
+
+	if err := n.showQueue(nil); err != nil {
+		return err
+	}
+
 	if n.forDownload.Len() != 0 {
 		logger.Info("Files for download:")
 		n.forDownload.ForEach(func(fileName string, file *ForDownloadFile) {
-			logger.Info("%s with size %d", fileName, file.FileSize)
-			len := uint16(file.LengthOfMissingChunks())
-			logger.Info("Chunks progress %d/%d (%.2f%%)", file.NumberOfChunks-len, file.NumberOfChunks, float64(file.NumberOfChunks-len)/float64(file.NumberOfChunks)*100)
+			logger.Info("%s with size %d, selecting chunks %s", fileName, file.FileSize, selection.StrategyName(file.Strategy))
+			wanted := file.NumberOfWantedChunks()
+			downloaded := wanted - file.LengthOfMissingChunks()
+			logger.Info("Chunks progress of %s: %d/%d (%.2f%%)", file.Range, downloaded, wanted, float64(downloaded)/float64(wanted)*100)
+		})
+	}
+
+	if directories := n.watcher.Directories(); len(directories) != 0 {
+		logger.Info("Watched directories:")
+		for _, directory := range directories {
+			logger.Info("%s", directory)
+		}
+	}
+
+	if n.failedDownloads.Len() != 0 {
+		logger.Info("Failed downloads:")
+		n.failedDownloads.ForEach(func(fileName string, err error) {
+			logger.Info("%s: %v", fileName, err)
 		})
 	}
 
 	logger.Info("Download directory path: %s", n.downloadDirectory)
+	if free, err := storage.FreeSpace(n.downloadDirectory); err == nil {
+		logger.Info("Free space in download directory: %d bytes", free)
+	}
+	logger.Info("On conflict: %s", ConflictPolicyName(n.conflictPolicy))
+	logger.Info("Upload slots in use: %d/%d", n.choker.NumberOfUnchoked(), UploadSlots)
+	logger.Info("Upload limit: %d bytes/s, %d bytes/s per peer", n.uploadLimits.GlobalRate(), n.uploadLimits.PeerRate())
+	logger.Info("Download limit: %d bytes/s, %d bytes/s per peer", n.downloadLimits.GlobalRate(), n.downloadLimits.PeerRate())
+	logger.Info("On change: %s", ChangePolicyName(n.changePolicy))
+	logger.Info("Sharing of downloads: %s", SharingPolicyName(n.defaultSharing))
+	logger.Info("Chunk selection of downloads: %s", selection.StrategyName(n.defaultStrategy))
+	n.sharing.ForEach(func(fileName string, policy uint8) {
+		logger.Info("Sharing of %s: %s", fileName, SharingPolicyName(policy))
+	})
 
 	return nil
 }
@@ -170,6 +284,211 @@ func (n *Node) setDownloadDirectory(args []string) error {
 	return nil
 }
 
+// watch <directory>
+func (n *Node) watch(args []string) error {
+	path := args[0]
+
+	stats, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !stats.IsDir() {
+		return fmt.Errorf("path %s is not a directory", path)
+	}
+
+	return n.watcher.Add(path)
+}
+
+// unwatch <directory>
+func (n *Node) unwatch(args []string) error {
+	return n.watcher.Remove(args[0])
+}
+
+// set-conflict <rename | skip | overwrite>
+func (n *Node) setConflictPolicy(args []string) error {
+	policy, err := ParseConflictPolicy(args[0])
+	if err != nil {
+		return err
+	}
+
+	n.conflictPolicy = policy
+
+	return nil
+}
+
+// set-change <withdraw | republish>
+func (n *Node) setChangePolicy(args []string) error {
+	policy, err := ParseChangePolicy(args[0])
+	if err != nil {
+		return err
+	}
+
+	n.changePolicy = policy
+
+	return nil
+}
+
+// set-sharing <file name> <seed | leech-only | private>
+func (n *Node) setSharing(args []string) error {
+	policy, err := ParseSharingPolicy(args[1])
+	if err != nil {
+		return err
+	}
+
+	n.applySharingPolicy(args[0], policy)
+
+	return nil
+}
+
+// set-default-sharing <seed | leech-only | private>
+func (n *Node) setDefaultSharing(args []string) error {
+	policy, err := ParseSharingPolicy(args[0])
+	if err != nil {
+		return err
+	}
+
+	n.defaultSharing = policy
+
+	return nil
+}
+
+// set-selection <file name> <rarest-first | sequential | random-first | priority>
+func (n *Node) setSelection(args []string) error {
+	strategy, err := selection.ParseStrategy(args[1])
+	if err != nil {
+		return err
+	}
+
+	if !n.isQueued(args[0]) {
+		return fmt.Errorf("file %s is not queued", args[0])
+	}
+	n.setQueuedStrategy(args[0], strategy)
+
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	if file, ok := n.forDownload.M[args[0]]; ok {
+		file.SetStrategy(strategy)
+	}
+
+	return nil
+}
+
+// set-default-selection <rarest-first | sequential | random-first | priority>
+func (n *Node) setDefaultSelection(args []string) error {
+	strategy, err := selection.ParseStrategy(args[0])
+	if err != nil {
+		return err
+	}
+
+	n.defaultStrategy = strategy
+
+	return nil
+}
+
+// set-priority <file name> <first chunk> <last chunk> <priority>
+func (n *Node) setPriority(args []string) error {
+	first, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil {
+		return err
+	}
+
+	last, err := strconv.ParseUint(args[2], 10, 16)
+	if err != nil {
+		return err
+	}
+
+	priority, err := strconv.Atoi(args[3])
+	if err != nil {
+		return err
+	}
+
+	if first > last {
+		return fmt.Errorf("first chunk %d is after last chunk %d", first, last)
+	}
+
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	file, ok := n.forDownload.M[args[0]]
+	if !ok {
+		return fmt.Errorf("file %s is not being downloaded", args[0])
+	}
+
+	selector, ok := file.Selector.(*selection.Priority)
+	if !ok {
+		return fmt.Errorf("file %s is not downloaded with the priority selection", args[0])
+	}
+
+	selector.SetPriority(uint16(first), uint16(last), priority)
+
+	return nil
+}
+
+// Returns the limits of the given direction, either upload or download
+func (n *Node) limits(direction string) (*ratelimit.Limits, error) {
+	switch direction {
+	case "upload":
+		return n.uploadLimits, nil
+	case "download":
+		return n.downloadLimits, nil
+	default:
+		return nil, fmt.Errorf("unknown direction %s, expected upload or download", direction)
+	}
+}
+
+// set-limit <upload | download> <bytes per second>
+func (n *Node) setLimit(args []string) error {
+	limits, err := n.limits(args[0])
+	if err != nil {
+		return err
+	}
+
+	rate, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	limits.SetGlobalRate(rate)
+
+	return nil
+}
+
+// set-peer-limit <upload | download> <bytes per second>
+func (n *Node) setPeerLimit(args []string) error {
+	limits, err := n.limits(args[0])
+	if err != nil {
+		return err
+	}
+
+	rate, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	limits.SetPeerRate(rate)
+
+	return nil
+}
+
+// set-file-limit <upload | download> <file name> <bytes per second>
+func (n *Node) setFileLimit(args []string) error {
+	limits, err := n.limits(args[0])
+	if err != nil {
+		return err
+	}
+
+	rate, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	limits.SetFileRate(args[1], rate)
+
+	return nil
+}
+
 // statistics
 func (n *Node) statistics(_ []string) error {
 	statistics := n.nodeStatistics
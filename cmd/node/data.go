@@ -2,26 +2,59 @@ package main
 
 import (
 	"PessiTorrent/internal/filewriter"
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/merkle"
+	"PessiTorrent/internal/pipeline"
 	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/selection"
+	"PessiTorrent/internal/storage"
 	"PessiTorrent/internal/structures"
+	"PessiTorrent/internal/utils"
+	"bytes"
+	"fmt"
 	"net"
+	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
-const (
-	ChunkTimeout = 500 * time.Millisecond
-)
-
 type File struct {
-	FileName string
-	Path     string
+	FileName      string
+	Path          string
+	HashAlgorithm uint8
+	FileHash      []byte
+	Storage       storage.Storage // Where the content of the file is read from
+
+	MerkleTree  *merkle.Tree // Nil if the file was published with every chunk hash
+	ChunkHashes [][]byte     // Used to verify chunks read from disk before serving them, nil to skip verification
+
+	// Size and modification time of the file on disk when it was hashed, used to detect changes
+	Size    int64
+	ModTime time.Time
+
+	// Set when the file changes before the tracker accepts its publish, along with how the change is handled once it does.
+	// Guarded by the pending files
+	ChangedWhilePending bool
+	PendingChangePolicy uint8
 }
 
-func NewFile(fileName string, path string) File {
+func NewFile(fileName string, path string, hashAlgorithm uint8, fileHash []byte, store storage.Storage) File {
 	return File{
-		FileName: fileName,
-		Path:     path,
+		FileName:      fileName,
+		Path:          path,
+		HashAlgorithm: hashAlgorithm,
+		FileHash:      fileHash,
+		Storage:       store,
+	}
+}
+
+// Returns the Merkle inclusion proof of the given chunk, empty if the file has no tree
+func (f *File) GetProof(chunkIndex uint16) [][]byte {
+	if f.MerkleTree == nil {
+		return nil
 	}
+
+	return f.MerkleTree.Proof(chunkIndex)
 }
 
 type ForDownloadFile struct {
@@ -31,27 +64,73 @@ type ForDownloadFile struct {
 	// Timestamp of when the download started
 	DownloadStarted time.Time
 
-	FileName   string
-	FilePath   string
-	FileHash   [20]byte
-	FileSize   uint64
-	FileWriter *filewriter.FileWriter
+	// Whether the file on disk is being/was checked against its hash, after every chunk was downloaded
+	Verifying bool
+	Verified  bool
+
+	// Whether the last chunks are being requested from several nodes at once, see EndgameThreshold
+	Endgame bool
+
+	FileName      string
+	FilePath      string // Destination of the file once downloaded and verified
+	PartPath      string // Where the file is written to while it is being downloaded
+	HashAlgorithm uint8
+	FileHash      []byte
+	FileSize      uint64
+	Storage       storage.Storage // Where the file is written to
+	FileWriter    *filewriter.FileWriter
+
+	HashMode   uint8
+	MerkleRoot []byte
+	// Chunk index -> Merkle inclusion proof received along with the chunk
+	Proofs structures.SynchronizedMap[uint16, [][]byte]
 
 	// Last time the node sent a UpdateChunksPacket to the tracker
 	LastServerChunksUpdate time.Time
 
+	// Whether the tracker already has the full bitfield of the file, so only deltas need to be sent
+	ReportedToTracker bool
+
+	// Chunks downloaded since the last update sent to the tracker
+	UnreportedChunks structures.SynchronizedList[uint16]
+
+	// Last time the node sent a PeerExchangePacket to the nodes of the file
+	LastPeerExchange time.Time
+
+	// Last time the downloaded chunks were saved next to the part file
+	LastStateSave time.Time
+
+	// Set by the file writer when the disk is full, requests are paused from PausedAt until some time after
+	DiskFull atomic.Bool
+	PausedAt time.Time
+
 	NumberOfChunks uint16
 	Chunks         structures.SynchronizedList[ChunkInfo]
 
-	PendingChunks structures.SynchronizedMap[uint16, time.Time] // Chunk index -> Last time chunk was requested
+	// Part of the file to download. Only the chunks covering it are wanted, every chunk if Wanted is nil
+	Range  DownloadRange
+	Wanted []bool
+	// Set once every wanted chunk of a range was downloaded, the chunks keep being served until the download is stopped
+	RangeDownloaded atomic.Bool
+	// Chunk index -> Hash of the chunk, only known once verified if the file has a Merkle tree
+	ChunkHashes structures.SynchronizedMap[uint16, []byte]
+
+	PendingChunks structures.SynchronizedMap[uint16, time.Time] // Chunk index -> When the chunk can be requested again
+
+	// Chunks downloaded since the last HavePacket was sent to the nodes of the file
+	UnannouncedChunks structures.SynchronizedList[uint16]
 
 	Nodes structures.SynchronizedMap[string, *NodeInfo]
+
+	// How many nodes have each chunk, kept up to date as nodes are added, updated and removed
+	Availability *selection.Availability
+	Strategy     uint8
+	Selector     selection.PieceSelector // Orders missing chunks by the strategy of the download
 }
 
 type ChunkInfo struct {
 	Index      uint16
 	Downloaded bool
-	Hash       [20]byte
 }
 
 type NodeInfo struct {
@@ -59,6 +138,14 @@ type NodeInfo struct {
 	// Chunk index -> Last time chunk was requested
 	Chunks   structures.SynchronizedMap[uint16, *RequestInfo]
 	Timeouts uint
+
+	Window  *pipeline.Window                              // How many chunks can be requested from the node at once, and for how long
+	Pending structures.SynchronizedMap[uint16, time.Time] // Chunk index -> When the chunk was requested from the node
+	Choked  atomic.Bool                                   // Whether the node has no upload slot for us
+
+	LastChokedRequest time.Time // Last time chunks were requested while choked
+
+	removed bool // Whether the node was removed from the file, so its chunks no longer count. Guarded by Chunks
 }
 
 type RequestInfo struct {
@@ -66,37 +153,85 @@ type RequestInfo struct {
 	NumberOfTries     uint
 }
 
-func NewForDownloadFile(fileName string) *ForDownloadFile {
+func NewForDownloadFile(fileName string, strategy uint8) *ForDownloadFile {
+	availability := selection.NewAvailability()
+
 	return &ForDownloadFile{
 		UpdatedByTracker:       false,
 		FileName:               fileName,
 		LastServerChunksUpdate: time.Now(),
+		Availability:           availability,
+		Strategy:               strategy,
+		Selector:               selection.New(strategy, availability),
 	}
 }
 
-func (f *ForDownloadFile) SetData(fileHash [20]byte, chunkHashes [][20]byte, fileSize uint64, numberOfChunks uint16, downloadDirectory string) error {
+// Changes the order the missing chunks are requested in
+func (f *ForDownloadFile) SetStrategy(strategy uint8) {
+	f.Strategy = strategy
+	f.Selector = selection.New(strategy, f.Availability)
+}
+
+func (f *ForDownloadFile) SetData(hashAlgorithm uint8, fileHash []byte, hashMode uint8, merkleRoot []byte, chunkHashes [][]byte, fileSize uint64, numberOfChunks uint16, downloadDirectory string, store storage.Storage) error {
+	f.HashAlgorithm = hashAlgorithm
 	f.FileHash = fileHash
 	f.FileSize = fileSize
-	f.FilePath = downloadDirectory + "/" + f.FileName
-	fileWriter, err := filewriter.NewFileWriter(f.FileName, fileSize, f.MarkChunkAsDownloaded, f.FilePath)
-	if err != nil {
-		return err
-	}
-	f.FileWriter = fileWriter
-	go f.FileWriter.Start()
+	f.Storage = store
+	f.HashMode = hashMode
+	f.MerkleRoot = merkleRoot
+	f.Proofs = structures.NewSynchronizedMap[uint16, [][]byte]()
+	f.FilePath = filepath.Join(downloadDirectory, f.FileName)
+	f.PartPath = f.FilePath + PartFileSuffix
 
 	f.NumberOfChunks = numberOfChunks
 	f.Chunks = structures.NewSynchronizedListWithInitialSize[ChunkInfo](uint(numberOfChunks))
+
+	if f.Range.Unit != WholeFile {
+		first, last, err := f.Range.Chunks(fileSize, numberOfChunks)
+		if err != nil {
+			return err
+		}
+
+		f.Wanted = make([]bool, numberOfChunks)
+		for i := int(first); i <= int(last); i++ {
+			f.Wanted[i] = true
+		}
+	}
+	f.ChunkHashes = structures.NewSynchronizedMap[uint16, []byte]()
 	for i := 0; i < int(numberOfChunks); i++ {
 		_ = f.Chunks.Set(uint(i), ChunkInfo{
 			Index:      uint16(i),
 			Downloaded: false,
-			Hash:       chunkHashes[i],
 		})
+
+		// With a Merkle tree, hashes are only known once each chunk is verified
+		if i < len(chunkHashes) {
+			f.ChunkHashes.Put(uint16(i), chunkHashes[i])
+		}
+	}
+
+	// Resume from the part file left by a previous run, if it is of this exact file
+	resumed, proofs := f.loadState()
+	if resumed == nil {
+		_ = f.Storage.Remove(f.PartPath)
+	}
+
+	fileWriter, err := filewriter.NewFileWriter(f.FileName, fileSize, f.MarkChunkAsDownloaded, f.handleWriteError, f.Storage, f.PartPath)
+	if err != nil {
+		return err
 	}
+	f.FileWriter = fileWriter
+	go f.FileWriter.Start()
 
 	f.Nodes = structures.NewSynchronizedMap[string, *NodeInfo]()
 	f.PendingChunks = structures.NewSynchronizedMap[uint16, time.Time]()
+	f.UnannouncedChunks = structures.NewSynchronizedList[uint16]()
+	f.UnreportedChunks = structures.NewSynchronizedList[uint16]()
+
+	// Chunks of a resumed download are only announced and served once they are verified again
+	for _, chunkIndex := range f.verifyResumedChunks(resumed, proofs) {
+		f.MarkChunkAsDownloaded(chunkIndex)
+	}
 
 	return nil
 }
@@ -111,57 +246,191 @@ func (f *ForDownloadFile) UpsertNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
 	} else {
 		f.addNode(nodeAddr, bitfield)
 	}
+}
 
-	nodeInfo := NodeInfo{
-		Address: nodeAddr.String(),
-		Chunks:  structures.NewSynchronizedMap[uint16, *RequestInfo](),
+// Adds the node only if it is not known yet, since second-hand information may be outdated
+func (f *ForDownloadFile) AddNodeIfAbsent(nodeAddr *net.UDPAddr, bitfield []uint8) bool {
+	if f.Nodes.Contains(nodeAddr.String()) {
+		return false
 	}
 
+	f.addNode(nodeAddr, bitfield)
+	return true
+}
+
+func (f *ForDownloadFile) addNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
+	nodeInfo := f.getOrAddNode(nodeAddr)
+
 	decoded := protocol.DecodeBitField(bitfield)
 	for index, hasChunk := range decoded {
+		if index >= int(f.NumberOfChunks) {
+			break // Padding of the last byte, or a bitfield longer than the file
+		}
+
 		if hasChunk {
-			nodeInfo.Chunks.Put(uint16(index), &RequestInfo{TimeLastRequested: time.Time{}})
+			f.addNodeChunk(nodeInfo, uint16(index))
 		}
 	}
-
-	f.Nodes.Put(nodeAddr.String(), &nodeInfo)
 }
 
-func (f *ForDownloadFile) addNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
-	nodeInfo := NodeInfo{
+// Returns the node, adding it without any chunks if it is not known yet
+func (f *ForDownloadFile) getOrAddNode(nodeAddr *net.UDPAddr) *NodeInfo {
+	f.Nodes.Lock()
+	defer f.Nodes.Unlock()
+
+	if nodeInfo, ok := f.Nodes.M[nodeAddr.String()]; ok {
+		return nodeInfo
+	}
+
+	nodeInfo := &NodeInfo{
 		Address: nodeAddr.String(),
 		Chunks:  structures.NewSynchronizedMap[uint16, *RequestInfo](),
+		Window:  pipeline.NewWindow(),
+		Pending: structures.NewSynchronizedMap[uint16, time.Time](),
 	}
+	f.Nodes.M[nodeAddr.String()] = nodeInfo
 
-	decoded := protocol.DecodeBitField(bitfield)
-	for index, hasChunk := range decoded {
-		if hasChunk {
-			nodeInfo.Chunks.Put(uint16(index), &RequestInfo{TimeLastRequested: time.Time{}})
+	return nodeInfo
+}
+
+// Marks the given chunks as available on the node, adding it if it is not known yet
+func (f *ForDownloadFile) AddNodeChunks(nodeAddr *net.UDPAddr, chunks []uint16) {
+	nodeInfo := f.getOrAddNode(nodeAddr)
+
+	for _, chunkIndex := range chunks {
+		if chunkIndex < f.NumberOfChunks {
+			f.addNodeChunk(nodeInfo, chunkIndex)
 		}
 	}
-
-	f.Nodes.Put(nodeAddr.String(), &nodeInfo)
 }
 
 func (f *ForDownloadFile) updateNode(nodeInfo *NodeInfo, bitfield []uint8) {
 	decoded := protocol.DecodeBitField(bitfield)
 	for index, hasChunk := range decoded {
+		if index >= int(f.NumberOfChunks) {
+			break // Padding of the last byte, or a bitfield longer than the file
+		}
+
 		if hasChunk {
-			nodeInfo.Chunks.Put(uint16(index), &RequestInfo{TimeLastRequested: time.Time{}})
+			f.addNodeChunk(nodeInfo, uint16(index))
 		} else {
-			nodeInfo.Chunks.Delete(uint16(index))
+			f.RemoveNodeChunk(nodeInfo, uint16(index))
 		}
 	}
 }
 
+func (f *ForDownloadFile) addNodeChunk(nodeInfo *NodeInfo, chunkIndex uint16) {
+	nodeInfo.Chunks.Lock()
+	defer nodeInfo.Chunks.Unlock()
+
+	if _, ok := nodeInfo.Chunks.M[chunkIndex]; ok || nodeInfo.removed {
+		return
+	}
+
+	nodeInfo.Chunks.M[chunkIndex] = &RequestInfo{TimeLastRequested: time.Time{}}
+	f.Availability.Add(chunkIndex)
+}
+
+// The chunk is no longer requested from the node, either because it does not have it or it keeps failing to send it
+func (f *ForDownloadFile) RemoveNodeChunk(nodeInfo *NodeInfo, chunkIndex uint16) {
+	nodeInfo.Chunks.Lock()
+	defer nodeInfo.Chunks.Unlock()
+
+	if _, ok := nodeInfo.Chunks.M[chunkIndex]; !ok || nodeInfo.removed {
+		return
+	}
+
+	delete(nodeInfo.Chunks.M, chunkIndex)
+	f.Availability.Remove(chunkIndex)
+}
+
+func (f *ForDownloadFile) RemoveNode(address string) {
+	nodeInfo, ok := f.Nodes.Get(address)
+	if !ok {
+		return
+	}
+	f.Nodes.Delete(address)
+
+	nodeInfo.Chunks.Lock()
+	defer nodeInfo.Chunks.Unlock()
+
+	if nodeInfo.removed {
+		return
+	}
+	nodeInfo.removed = true
+
+	for chunkIndex := range nodeInfo.Chunks.M {
+		f.Availability.Remove(chunkIndex)
+	}
+}
+
+// The chunk is not requested again, from any node, until the node it was requested from times out
 func (f *ForDownloadFile) MarkChunkAsRequested(chunkIndex uint16, nodeInfo *NodeInfo) {
-	nodeInfo.Chunks.Put(chunkIndex, &RequestInfo{TimeLastRequested: time.Now()})
+	now := time.Now()
+	nodeInfo.Pending.Put(chunkIndex, now)
+	f.PendingChunks.Put(chunkIndex, now.Add(nodeInfo.Window.Timeout()))
+
+	if requestInfo, ok := nodeInfo.Chunks.Get(chunkIndex); ok {
+		requestInfo.TimeLastRequested = now
+	}
+}
+
+// Returns true if the chunk was requested from some node and it may still arrive
+func (f *ForDownloadFile) IsChunkPending(chunkIndex uint16) bool {
+	deadline, ok := f.PendingChunks.Get(chunkIndex)
+	return ok && time.Now().Before(deadline)
 }
 
 func (f *ForDownloadFile) MarkChunkAsDownloaded(chunkIndex uint16) {
 	chunk, _ := f.Chunks.Get(uint(chunkIndex))
 	chunk.Downloaded = true
 	_ = f.Chunks.Set(uint(chunkIndex), chunk)
+
+	f.UnannouncedChunks.Add(chunkIndex)
+	f.UnreportedChunks.Add(chunkIndex)
+}
+
+func (f *ForDownloadFile) MarkChunkAsMissing(chunkIndex uint16) {
+	chunk, _ := f.Chunks.Get(uint(chunkIndex))
+	chunk.Downloaded = false
+	_ = f.Chunks.Set(uint(chunkIndex), chunk)
+}
+
+// Chunks that could not be written are downloaded again
+func (f *ForDownloadFile) handleWriteError(chunkIndex uint16, err error) {
+	logger.Error("Error writing chunk %d of file %s: %v", chunkIndex, f.FileName, err)
+
+	f.MarkChunkAsMissing(chunkIndex)
+	f.PendingChunks.Delete(chunkIndex)
+
+	if filewriter.IsDiskFull(err) {
+		f.DiskFull.Store(true)
+	}
+}
+
+// Recomputes the hashes of the file written to the storage, returning the chunks that do not match theirs
+func (f *ForDownloadFile) VerifyOnDisk() ([]uint16, error) {
+	fileHash, chunkHashes, err := utils.HashReaderAndChunks(storage.NewReader(f.Storage, f.PartPath), f.FileSize, f.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(fileHash, f.FileHash) {
+		return nil, nil
+	}
+
+	corruptedChunks := make([]uint16, 0)
+	for i, chunkHash := range chunkHashes {
+		if !bytes.Equal(chunkHash, f.GetChunkHash(uint16(i))) {
+			corruptedChunks = append(corruptedChunks, uint16(i))
+		}
+	}
+
+	if len(corruptedChunks) == 0 {
+		return nil, fmt.Errorf("every chunk matches its hash, but the file does not")
+	}
+
+	return corruptedChunks, nil
 }
 
 func (f *ForDownloadFile) ChunkAlreadyDownloaded(chunkIndex uint16) bool {
@@ -169,9 +438,46 @@ func (f *ForDownloadFile) ChunkAlreadyDownloaded(chunkIndex uint16) bool {
 	return chunk.Downloaded
 }
 
-func (f *ForDownloadFile) GetChunkHash(chunkIndex uint16) [20]byte {
-	chunk, _ := f.Chunks.Get(uint(chunkIndex))
-	return chunk.Hash
+func (f *ForDownloadFile) GetChunkHash(chunkIndex uint16) []byte {
+	hash, _ := f.ChunkHashes.Get(chunkIndex)
+	return hash
+}
+
+func (f *ForDownloadFile) GetChunkHashes() [][]byte {
+	chunkHashes := make([][]byte, f.NumberOfChunks)
+	f.ChunkHashes.ForEach(func(chunkIndex uint16, hash []byte) {
+		chunkHashes[chunkIndex] = hash
+	})
+
+	return chunkHashes
+}
+
+// Returns true if the chunk content matches its hash, or its Merkle proof leads to the root of the file
+func (f *ForDownloadFile) VerifyChunk(chunkIndex uint16, chunkContent []uint8, proof [][]byte) bool {
+	hash := utils.HashChunk(f.HashAlgorithm, chunkContent)
+	if hash == nil || chunkIndex >= f.NumberOfChunks {
+		return false
+	}
+
+	if f.HashMode != protocol.MerkleHashMode {
+		return bytes.Equal(f.GetChunkHash(chunkIndex), hash)
+	}
+
+	newHash, err := utils.HashFunction(f.HashAlgorithm)
+	if err != nil || !merkle.Verify(newHash, f.MerkleRoot, hash, chunkIndex, f.NumberOfChunks, proof) {
+		return false
+	}
+
+	// Keep the verified hash and proof, in order to serve the chunk and later rebuild the tree
+	f.ChunkHashes.Put(chunkIndex, hash)
+	f.Proofs.Put(chunkIndex, proof)
+
+	return true
+}
+
+func (f *ForDownloadFile) GetChunkProof(chunkIndex uint16) [][]byte {
+	proof, _ := f.Proofs.Get(chunkIndex)
+	return proof
 }
 
 func (f *ForDownloadFile) GetDownloadedChunks() []uint {
@@ -182,46 +488,77 @@ func (f *ForDownloadFile) GetDownloadedChunks() []uint {
 	return downloadedChunks
 }
 
+// Returns the chunks not downloaded yet, out of those wanted
 func (f *ForDownloadFile) GetMissingChunks() []uint {
 	missingChunks := f.Chunks.IndexesWhere(func(chunk ChunkInfo) bool {
-		return !chunk.Downloaded
+		return !chunk.Downloaded && f.IsChunkWanted(chunk.Index)
 	})
 
 	return missingChunks
 }
 
-func (f *ForDownloadFile) GetNumberOfNodesWhichHaveChunk(chunkIndex uint16) uint {
-	var numberOfNodes uint = 0
-	f.Nodes.ForEach(func(nodeAddrString string, nodeInfo *NodeInfo) {
-		_, ok := nodeInfo.Chunks.Get(chunkIndex)
-		if ok {
-			numberOfNodes++
+func (f *ForDownloadFile) IsChunkWanted(chunkIndex uint16) bool {
+	return f.Wanted == nil || f.Wanted[chunkIndex]
+}
+
+// Returns how many chunks are downloaded, the whole file unless only a range of it is
+func (f *ForDownloadFile) NumberOfWantedChunks() int {
+	if f.Wanted == nil {
+		return int(f.NumberOfChunks)
+	}
+
+	wanted := 0
+	for _, isWanted := range f.Wanted {
+		if isWanted {
+			wanted++
 		}
+	}
+
+	return wanted
+}
+
+func (f *ForDownloadFile) GetNumberOfNodesWhichHaveChunk(chunkIndex uint16) uint {
+	return uint(f.Availability.Count(chunkIndex))
+}
+
+// Returns the encoded bitfield of the chunks already downloaded
+func (f *ForDownloadFile) Bitfield() protocol.Bitfield {
+	bitfield := make([]bool, 0)
+	f.Chunks.ForEach(func(chunkInfo ChunkInfo) {
+		bitfield = append(bitfield, chunkInfo.Downloaded)
 	})
 
-	return numberOfNodes
+	return protocol.EncodeBitField(bitfield)
 }
 
 func (f *ForDownloadFile) LengthOfMissingChunks() int {
 	return len(f.GetMissingChunks())
 }
 
-func (n *NodeInfo) ShouldRequestChunk(chunkIndex uint16) bool {
-	chunk, ok := n.GetLastTimeChunkWasRequested(chunkIndex)
-	if !ok {
-		return false
-	}
+// Returns the encoded bitfield of the chunks the node is known to have
+func (n *NodeInfo) Bitfield(numberOfChunks uint16) protocol.Bitfield {
+	bitfield := make([]bool, numberOfChunks)
+	n.Chunks.ForEach(func(index uint16, _ *RequestInfo) {
+		if index < numberOfChunks {
+			bitfield[index] = true
+		}
+	})
 
-	// Chunk was not requested yet or it was requested more than the chunk timeout ago
-	// The expected time could be improved by calculating the average time between requests
-	return chunk == time.Time{} || time.Since(chunk) > ChunkTimeout
+	return protocol.EncodeBitField(bitfield)
 }
 
-func (n *NodeInfo) GetLastTimeChunkWasRequested(chunkIndex uint16) (time.Time, bool) {
-	requestInfo, ok := n.Chunks.Get(chunkIndex)
-	return requestInfo.TimeLastRequested, ok
+// Records that the chunk arrived from the node, returning when it was requested from it
+func (n *NodeInfo) ReceiveChunk(chunkIndex uint16) (time.Time, bool) {
+	requested, ok := n.Pending.Get(chunkIndex)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	n.Pending.Delete(chunkIndex)
+	n.Window.OnReceived(time.Since(requested))
+	return requested, true
 }
 
-func (f *ForDownloadFile) WriteChunkToDisk(chunkIndex uint16, chunkContent []uint8) {
-	f.FileWriter.EnqueueChunkToWrite(chunkIndex, chunkContent)
+func (f *ForDownloadFile) WriteChunkToDisk(chunkIndex uint16, chunkContent []uint8) error {
+	return f.FileWriter.EnqueueChunkToWrite(chunkIndex, chunkContent)
 }
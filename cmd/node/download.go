@@ -0,0 +1,305 @@
+package main
+
+import (
+	"PessiTorrent/internal/filereader"
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/storage"
+	"PessiTorrent/internal/utils"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	PartFileSuffix  = ".part"  // Suffix of files still being downloaded
+	StateFileSuffix = ".state" // Suffix of the sidecar file with the state of a download, next to its part file
+)
+
+// What to do when the destination of a download already exists
+const (
+	RenameOnConflict    = 0 // Download to "name (1).ext", "name (2).ext", ...
+	SkipOnConflict      = 1 // Do not download the file at all
+	OverwriteOnConflict = 2 // Replace the existing file once the download completes
+)
+
+var conflictPolicyNames = map[uint8]string{
+	RenameOnConflict:    "rename",
+	SkipOnConflict:      "skip",
+	OverwriteOnConflict: "overwrite",
+}
+
+func ConflictPolicyName(policy uint8) string {
+	return conflictPolicyNames[policy]
+}
+
+func ParseConflictPolicy(name string) (uint8, error) {
+	for policy, policyName := range conflictPolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return policy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown conflict policy: %s", name)
+}
+
+// What the bounds of a download range are given in
+const (
+	WholeFile  = 0 // Not a range, the whole file is downloaded
+	ByteRange  = 1
+	ChunkRange = 2
+)
+
+var rangeUnitNames = map[uint8]string{
+	ByteRange:  "bytes",
+	ChunkRange: "chunks",
+}
+
+// DownloadRange is the part of a file to download, from the first to the last byte or chunk, both included
+type DownloadRange struct {
+	Unit  uint8
+	First uint64
+	Last  uint64
+}
+
+// Parses a range such as "bytes 0-1023" or "chunks 4-7"
+func ParseDownloadRange(unitName string, bounds string) (DownloadRange, error) {
+	var downloadRange DownloadRange
+	for unit, name := range rangeUnitNames {
+		if strings.EqualFold(unitName, name) {
+			downloadRange.Unit = unit
+		}
+	}
+	if downloadRange.Unit == WholeFile {
+		return downloadRange, fmt.Errorf("unknown range unit %s, expected bytes or chunks", unitName)
+	}
+
+	first, last, ok := strings.Cut(bounds, "-")
+	if !ok {
+		return downloadRange, fmt.Errorf("invalid range %s, expected <first>-<last>", bounds)
+	}
+
+	var err error
+	if downloadRange.First, err = strconv.ParseUint(first, 10, 64); err != nil {
+		return downloadRange, err
+	}
+	if downloadRange.Last, err = strconv.ParseUint(last, 10, 64); err != nil {
+		return downloadRange, err
+	}
+
+	if downloadRange.First > downloadRange.Last {
+		return downloadRange, fmt.Errorf("invalid range %s, it ends before it starts", bounds)
+	}
+
+	return downloadRange, nil
+}
+
+func (r DownloadRange) String() string {
+	if r.Unit == WholeFile {
+		return "whole file"
+	}
+
+	return fmt.Sprintf("%s %d-%d", rangeUnitNames[r.Unit], r.First, r.Last)
+}
+
+// Returns the first and last chunks covering the range, which is clipped to the end of the file
+func (r DownloadRange) Chunks(fileSize uint64, numberOfChunks uint16) (uint16, uint16, error) {
+	if numberOfChunks == 0 {
+		return 0, 0, fmt.Errorf("file is empty")
+	}
+
+	switch r.Unit {
+	case ByteRange:
+		if r.First >= fileSize {
+			return 0, 0, fmt.Errorf("range %s starts past the end of the file, of %d bytes", r, fileSize)
+		}
+
+		chunkSize := utils.ChunkSize(fileSize)
+		last := min(r.Last, fileSize-1)
+		return uint16(r.First / chunkSize), uint16(last / chunkSize), nil
+	case ChunkRange:
+		if r.First >= uint64(numberOfChunks) {
+			return 0, 0, fmt.Errorf("range %s starts past the end of the file, of %d chunks", r, numberOfChunks)
+		}
+
+		last := min(r.Last, uint64(numberOfChunks)-1)
+		return uint16(r.First), uint16(last), nil
+	default:
+		return 0, numberOfChunks - 1, nil
+	}
+}
+
+// DownloadState is persisted next to a part file, so the download can be resumed later on
+type DownloadState struct {
+	HashAlgorithm uint8
+	FileHash      []byte
+	FileSize      uint64
+	Bitfield      protocol.Bitfield
+	Proofs        [][][]byte // Merkle inclusion proof of each downloaded chunk, empty if the file has no tree
+}
+
+// Persists which chunks were already downloaded, replacing the previous state atomically
+func (f *ForDownloadFile) SaveState() error {
+	state := DownloadState{
+		HashAlgorithm: f.HashAlgorithm,
+		FileHash:      f.FileHash,
+		FileSize:      f.FileSize,
+		Bitfield:      f.Bitfield(),
+		Proofs:        make([][][]byte, 0),
+	}
+
+	// Chunks are verified again when the download is resumed, which needs their proofs
+	if f.HashMode == protocol.MerkleHashMode {
+		for i := uint16(0); i < f.NumberOfChunks; i++ {
+			state.Proofs = append(state.Proofs, f.GetChunkProof(i))
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	err := protocol.SerializeStruct(buffer, &state)
+	if err != nil {
+		return err
+	}
+
+	statePath := f.PartPath + StateFileSuffix
+	err = os.WriteFile(statePath+".tmp", buffer.Bytes(), storage.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(statePath+".tmp", statePath)
+}
+
+// Returns the chunks already downloaded by a previous run along with their proofs,
+// or nil if there is no state for this exact file
+func (f *ForDownloadFile) loadState() ([]bool, [][][]byte) {
+	stateFile, err := os.Open(f.PartPath + StateFileSuffix)
+	if err != nil {
+		return nil, nil
+	}
+	defer stateFile.Close()
+
+	var state DownloadState
+	err = protocol.DeserializeToStruct(bufio.NewReader(stateFile), &state)
+	if err != nil {
+		return nil, nil
+	}
+
+	if state.HashAlgorithm != f.HashAlgorithm || state.FileSize != f.FileSize || !bytes.Equal(state.FileHash, f.FileHash) {
+		return nil, nil // State belongs to a different file with the same name
+	}
+
+	if !f.Storage.Exists(f.PartPath) {
+		return nil, nil
+	}
+
+	return protocol.DecodeBitField(state.Bitfield), state.Proofs
+}
+
+// Hashes again the chunks a previous run downloaded, since the part file may have changed since,
+// and returns those that still match their hash or proof. The rest are downloaded again
+func (f *ForDownloadFile) verifyResumedChunks(resumed []bool, proofs [][][]byte) []uint16 {
+	chunkSize := utils.ChunkSize(f.FileSize)
+	buffer := filereader.GetBuffer(int(chunkSize))
+	defer filereader.PutBuffer(buffer)
+
+	verified := make([]uint16, 0)
+	corrupted := 0
+	for i, downloaded := range resumed {
+		if !downloaded || i >= int(f.NumberOfChunks) {
+			continue
+		}
+		chunkIndex := uint16(i)
+
+		var proof [][]byte
+		if i < len(proofs) {
+			proof = proofs[i]
+		}
+
+		read, err := f.Storage.ReadAt(f.PartPath, buffer, int64(uint64(chunkIndex)*chunkSize))
+		if err != nil || !f.VerifyChunk(chunkIndex, buffer[:read], proof) {
+			corrupted++
+			continue
+		}
+
+		verified = append(verified, chunkIndex)
+	}
+
+	if corrupted > 0 {
+		logger.Warn("%d chunks of file %s changed in its part file, downloading them again", corrupted, f.FileName)
+	}
+
+	return verified
+}
+
+// Atomically renames the part file to its destination, following the given conflict policy,
+// and returns the final path of the file
+func (f *ForDownloadFile) MoveIntoPlace(conflictPolicy uint8) (string, error) {
+	destination := f.FilePath
+	if conflictPolicy != OverwriteOnConflict {
+		// Whatever appeared at the destination during the download is never overwritten
+		destination = availablePath(f.Storage, f.FilePath)
+	}
+
+	err := f.Storage.Rename(f.PartPath, destination)
+	if err != nil {
+		return f.PartPath, err
+	}
+
+	_ = os.Remove(f.PartPath + StateFileSuffix)
+
+	return destination, nil
+}
+
+// Fails if the download directory does not have room for a file of the given size, besides what its part file
+// already takes when the download is resumed. Platforms that can not tell how much space is free are never refused
+func (n *Node) checkFreeSpace(fileSize uint64, partPath string) error {
+	free, err := storage.FreeSpace(n.downloadDirectory)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not check free space in %s: %v", n.downloadDirectory, err)
+	}
+
+	needed := fileSize
+	if allocated, err := storage.AllocatedSpace(partPath); err == nil {
+		needed -= min(needed, allocated)
+	}
+
+	if free < needed {
+		return fmt.Errorf("not enough free space in %s: %d bytes needed, %d bytes available", n.downloadDirectory, needed, free)
+	}
+
+	return nil
+}
+
+// Gives up on downloading a file, keeping why so it is shown in the status
+func (n *Node) failDownload(fileName string, err error) {
+	logger.Error("Download of file %s failed: %v", fileName, err)
+
+	n.forDownload.Delete(fileName)
+	n.failedDownloads.Put(fileName, err)
+}
+
+// Returns the given path if nothing exists there, otherwise the first "name (n).ext" that is free
+func availablePath(store storage.Storage, path string) string {
+	if !store.Exists(path) {
+		return path
+	}
+
+	extension := filepath.Ext(path)
+	base := strings.TrimSuffix(path, extension)
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, extension)
+		if !store.Exists(candidate) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestParseDownloadRange(t *testing.T) {
+	testCases := []struct {
+		unit          string
+		bounds        string
+		expected      DownloadRange
+		expectedError bool
+	}{
+		{"bytes", "0-1023", DownloadRange{ByteRange, 0, 1023}, false},
+		{"BYTES", "5-5", DownloadRange{ByteRange, 5, 5}, false},
+		{"Chunks", "4-7", DownloadRange{ChunkRange, 4, 7}, false},
+		{"bytes", "10-5", DownloadRange{}, true},  // Ends before it starts
+		{"blocks", "0-1", DownloadRange{}, true},  // Unknown unit
+		{"bytes", "10", DownloadRange{}, true},    // Missing last
+		{"chunks", "a-5", DownloadRange{}, true},  // Not a number
+		{"chunks", "-1-5", DownloadRange{}, true}, // Negative
+	}
+
+	for _, tc := range testCases {
+		result, err := ParseDownloadRange(tc.unit, tc.bounds)
+		if tc.expectedError {
+			if err == nil {
+				t.Errorf("ParseDownloadRange(%s, %s): expected an error, got %v", tc.unit, tc.bounds, result)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseDownloadRange(%s, %s): unexpected error: %v", tc.unit, tc.bounds, err)
+		} else if result != tc.expected {
+			t.Errorf("ParseDownloadRange(%s, %s): expected %v, got %v", tc.unit, tc.bounds, tc.expected, result)
+		}
+	}
+}
+
+func TestDownloadRangeChunks(t *testing.T) {
+	// Chunks of 16000 bytes, the last one holding only 8000
+	const fileSize = 40000
+	const numberOfChunks = 3
+
+	testCases := []struct {
+		downloadRange DownloadRange
+		expectedFirst uint16
+		expectedLast  uint16
+		expectedError bool
+	}{
+		{DownloadRange{WholeFile, 0, 0}, 0, 2, false},
+		{DownloadRange{ByteRange, 0, 1023}, 0, 0, false},
+		{DownloadRange{ByteRange, 15999, 16000}, 0, 1, false},
+		{DownloadRange{ByteRange, 16000, 50000}, 1, 2, false}, // Clipped at the end of the file
+		{DownloadRange{ByteRange, 39999, 39999}, 2, 2, false}, // Last byte, in the last partial chunk
+		{DownloadRange{ByteRange, 40000, 50000}, 0, 0, true},  // Starts past the end of the file
+		{DownloadRange{ChunkRange, 1, 1}, 1, 1, false},
+		{DownloadRange{ChunkRange, 2, 9}, 2, 2, false}, // Clipped at the last chunk
+		{DownloadRange{ChunkRange, 3, 4}, 0, 0, true},  // Starts past the last chunk
+	}
+
+	for _, tc := range testCases {
+		first, last, err := tc.downloadRange.Chunks(fileSize, numberOfChunks)
+		if tc.expectedError {
+			if err == nil {
+				t.Errorf("Chunks of %s: expected an error, got %d-%d", tc.downloadRange, first, last)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Chunks of %s: unexpected error: %v", tc.downloadRange, err)
+		} else if first != tc.expectedFirst || last != tc.expectedLast {
+			t.Errorf("Chunks of %s: expected %d-%d, got %d-%d", tc.downloadRange, tc.expectedFirst, tc.expectedLast, first, last)
+		}
+	}
+
+	if _, _, err := (DownloadRange{ByteRange, 0, 0}).Chunks(0, 0); err == nil {
+		t.Errorf("Chunks of an empty file: expected an error")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"net"
+	"time"
+)
+
+const (
+	EndgameThreshold        = 16 // Missing chunks below which a download enters endgame mode
+	EndgameRequestsPerChunk = 3  // Nodes each missing chunk is requested from at once in endgame mode
+	CancelTimeout           = 30 * time.Second
+)
+
+// A chunk a node no longer wants from us
+type cancelKey struct {
+	Address  string
+	FileName string
+	Chunk    uint16
+}
+
+// Returns how many nodes each chunk is currently requested from
+func (f *ForDownloadFile) RequestsPerChunk() map[uint16]int {
+	requests := make(map[uint16]int)
+	for _, nodeInfo := range f.Nodes.Values() {
+		for _, chunkIndex := range nodeInfo.Pending.Keys() {
+			requests[chunkIndex]++
+		}
+	}
+
+	return requests
+}
+
+// Tells every other node the chunk was requested from that it is no longer needed
+func (n *Node) cancelDuplicates(file *ForDownloadFile, chunkIndex uint16, receivedFrom string) {
+	for _, nodeInfo := range file.Nodes.Values() {
+		if nodeInfo.Address == receivedFrom || !nodeInfo.Pending.Contains(chunkIndex) {
+			continue
+		}
+
+		// Not a timeout, so the node's window is left as is
+		nodeInfo.Pending.Delete(chunkIndex)
+
+		nodeAddr, err := net.ResolveUDPAddr("udp4", nodeInfo.Address)
+		if err != nil {
+			continue
+		}
+
+		packet := protocol.NewCancelPacket(file.FileName, []uint16{chunkIndex})
+		n.srv.SendPacket(&packet, nodeAddr)
+	}
+}
+
+// Handler for when a node no longer needs chunks it requested from us
+func (n *Node) handleCancelPacket(packet *protocol.CancelPacket, addr *net.UDPAddr) {
+	n.cancelled.Lock()
+	defer n.cancelled.Unlock()
+
+	// Cancellations of chunks that were already sent are never consumed
+	for key, cancelled := range n.cancelled.M {
+		if time.Since(cancelled) > CancelTimeout {
+			delete(n.cancelled.M, key)
+		}
+	}
+
+	for _, chunkIndex := range packet.Chunks {
+		n.cancelled.M[cancelKey{addr.String(), packet.FileName, chunkIndex}] = time.Now()
+	}
+
+	logger.Info("Node %s cancelled %d chunks of file %s", addr, len(packet.Chunks), packet.FileName)
+}
+
+// Returns true if the node cancelled its request for the chunk, which then no longer needs to be sent
+func (n *Node) takeCancelled(address string, fileName string, chunkIndex uint16) bool {
+	key := cancelKey{address, fileName, chunkIndex}
+
+	cancelled, ok := n.cancelled.Get(key)
+	if !ok {
+		return false
+	}
+
+	n.cancelled.Delete(key)
+	return time.Since(cancelled) <= CancelTimeout
+}
+
+// A new request for chunks supersedes earlier cancellations of them
+func (n *Node) clearCancelled(address string, fileName string, chunks []uint16) {
+	for _, chunkIndex := range chunks {
+		n.cancelled.Delete(cancelKey{address, fileName, chunkIndex})
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"PessiTorrent/internal/filewriter"
 	"PessiTorrent/internal/logger"
 	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/storage"
 	"PessiTorrent/internal/transport"
 	"PessiTorrent/internal/utils"
 	"errors"
-	"io"
+	"fmt"
 	"net"
-	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -25,6 +27,10 @@ func (n *Node) HandlePackets(packet protocol.Packet, conn *transport.TCPConnecti
 		n.handleAlreadyExistsPacket(packet, conn)
 	case *protocol.NotFoundPacket:
 		n.handleNotFoundPacket(packet, conn)
+	case *protocol.InvalidPublishPacket:
+		n.handleInvalidPublishPacket(packet, conn)
+	case *protocol.NodeUpdatePacket:
+		n.handleNodeUpdatePacket(packet, conn)
 	default:
 		logger.Warn("Unknown packet type: %v.", packet)
 	}
@@ -36,6 +42,20 @@ func (n *Node) HandleUDPPackets(packet protocol.Packet, addr *net.UDPAddr) {
 		n.handleChunkPacket(data, addr)
 	case *protocol.RequestChunksPacket:
 		n.handleRequestChunksPacket(data, addr)
+	case *protocol.AnnouncePacket:
+		n.handleAnnouncePacket(data, addr)
+	case *protocol.PeerExchangePacket:
+		n.handlePeerExchangePacket(data, addr)
+	case *protocol.HavePacket:
+		n.handleHavePacket(data, addr)
+	case *protocol.ChokePacket:
+		n.handleChokePacket(data, addr)
+	case *protocol.UnchokePacket:
+		n.handleUnchokePacket(data, addr)
+	case *protocol.RejectPacket:
+		n.handleRejectPacket(data, addr)
+	case *protocol.CancelPacket:
+		n.handleCancelPacket(data, addr)
 	default:
 		logger.Warn("Unknown packet type: %v.", data)
 	}
@@ -51,40 +71,78 @@ func (n *Node) handleAnswerFileWithNodesPacket(packet *protocol.AnswerFileWithNo
 
 	logger.Info("Updating nodes who have chunks for file %s", packet.FileName)
 
-	err := forDownloadFile.SetData(packet.FileHash, packet.ChunkHashes, packet.FileSize, uint16(len(packet.ChunkHashes)), n.downloadDirectory)
+	if utils.HashSize(packet.HashAlgorithm) == 0 {
+		logger.Error("File %s was published with an unsupported hash algorithm %d", packet.FileName, packet.HashAlgorithm)
+		n.forDownload.Delete(packet.FileName)
+		return
+	}
+
+	if n.conflictPolicy == SkipOnConflict && n.storage.Exists(filepath.Join(n.downloadDirectory, packet.FileName)) {
+		logger.Info("File %s already exists in %s, skipping download", packet.FileName, n.downloadDirectory)
+		n.forDownload.Delete(packet.FileName)
+		return
+	}
+
+	numberOfChunks := uint16(len(packet.ChunkHashes))
+	if packet.HashMode == protocol.MerkleHashMode {
+		numberOfChunks = uint16(utils.NumberOfChunks(packet.FileSize))
+	}
+
+	// Only the chunks covering a range need room
+	requiredSpace := packet.FileSize
+	if forDownloadFile.Range.Unit != WholeFile {
+		first, last, err := forDownloadFile.Range.Chunks(packet.FileSize, numberOfChunks)
+		if err != nil {
+			n.failDownload(packet.FileName, err)
+			return
+		}
+		requiredSpace = min(packet.FileSize, (uint64(last)-uint64(first)+1)*utils.ChunkSize(packet.FileSize))
+	}
+
+	partPath := filepath.Join(n.downloadDirectory, packet.FileName) + PartFileSuffix
+	if err := n.checkFreeSpace(requiredSpace, partPath); err != nil {
+		n.failDownload(packet.FileName, err)
+		return
+	}
+
+	err := forDownloadFile.SetData(packet.HashAlgorithm, packet.FileHash, packet.HashMode, packet.MerkleRoot, packet.ChunkHashes, packet.FileSize, numberOfChunks, n.downloadDirectory, n.storage)
 	if err != nil {
-		logger.Error("Error setting data for file %s: %v", packet.FileName, err)
+		n.failDownload(packet.FileName, fmt.Errorf("error setting data: %v", err))
 		return
 	}
+
+	if n.preallocate && forDownloadFile.Range.Unit == WholeFile {
+		err = storage.Preallocate(n.storage, forDownloadFile.PartPath, packet.FileSize)
+		if filewriter.IsDiskFull(err) {
+			n.forDownload.Lock()
+			n.stopDownload(packet.FileName, forDownloadFile)
+			n.forDownload.Unlock()
+			n.failDownload(packet.FileName, fmt.Errorf("not enough free space in %s to preallocate %d bytes", n.downloadDirectory, packet.FileSize))
+			return
+		}
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			logger.Warn("Error preallocating file %s, downloading it anyway: %v", packet.FileName, err)
+		}
+	}
 	forDownloadFile.DownloadStarted = time.Now()
 	forDownloadFile.UpdatedByTracker = true
 
 	for _, node := range packet.Nodes {
-		ipAddrStr, err := n.dns.ResolveIP(node.Name)
-		if err != nil {
-			logger.Error("Error resolving dns ip address on %s: %v", node.Name, err)
-			continue
-		}
-		ipAddrStr = ipAddrStr + ":" + strconv.Itoa(int(node.Port))
-		ipAddr, err := net.ResolveUDPAddr("udp", ipAddrStr)
-
+		udpAddr, err := n.resolveNodeAddr(node.Name, node.Port)
 		if err != nil {
 			logger.Error("Error resolving domain %s: %v", node.Name, err)
 			continue
 		}
 
-		udpAddr := net.UDPAddr{
-			IP:   ipAddr.IP,
-			Port: int(node.Port),
-		}
-
-		localIpAddr := utils.TCPAddrToBytes(n.conn.LocalAddr())
-
-		if n.udpPort != node.Port || localIpAddr != [4]byte(ipAddr.IP) { // Do not add itself to the list of nodes
-			forDownloadFile.UpsertNode(&udpAddr, node.Bitfield)
+		if !n.isSelf(udpAddr) { // Do not add itself to the list of nodes
+			forDownloadFile.UpsertNode(udpAddr, node.Bitfield)
 		}
 	}
 
+	// Be notified about changes to the nodes who have the file from now on
+	subscribePacket := protocol.NewSubscribeFilePacket(packet.FileName)
+	n.conn.EnqueuePacket(&subscribePacket)
+
 	logger.Info("File %s information internally updated.", packet.FileName)
 }
 
@@ -99,27 +157,66 @@ func (n *Node) handleAnswerNodesPacket(packet *protocol.AnswerNodesPacket, conn
 	logger.Info("Updating nodes who have chunks for file %s", packet.FileName)
 
 	for _, node := range packet.Nodes {
-		ipAddrStr, _ := n.dns.ResolveIP(node.Name)
-		ipAddrStr = ipAddrStr + ":" + strconv.Itoa(int(node.Port))
-		ipAddr, err := net.ResolveUDPAddr("udp", ipAddrStr)
+		udpAddr, err := n.resolveNodeAddr(node.Name, node.Port)
 		if err != nil {
 			logger.Error("Error resolving domain %s: %v", node.Name, err)
 			continue
 		}
-		udpAddr := net.UDPAddr{
-			IP:   ipAddr.IP,
-			Port: int(node.Port),
-		}
-		localIpAddr := utils.TCPAddrToBytes(n.conn.LocalAddr())
 
-		if n.udpPort != node.Port || localIpAddr != [4]byte(ipAddr.IP) { // Do not add itself to the list of nodes
-			forDownloadFile.UpsertNode(&udpAddr, node.Bitfield)
+		if !n.isSelf(udpAddr) { // Do not add itself to the list of nodes
+			forDownloadFile.UpsertNode(udpAddr, node.Bitfield)
 		}
 	}
 
 	logger.Info("File %s information internally updated.", packet.FileName)
 }
 
+// Handler for when the tracker pushes a change to the nodes who have a file we are subscribed to
+func (n *Node) handleNodeUpdatePacket(packet *protocol.NodeUpdatePacket, conn *transport.TCPConnection) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return // File was removed from forDownload files
+	}
+
+	udpAddr, err := n.resolveNodeAddr(packet.Node.Name, packet.Node.Port)
+	if err != nil {
+		logger.Error("Error resolving domain %s: %v", packet.Node.Name, err)
+		return
+	}
+
+	if n.isSelf(udpAddr) {
+		return // Do not add itself to the list of nodes
+	}
+
+	switch packet.Type {
+	case protocol.NodeJoined:
+		logger.Info("Node %s joined file %s", udpAddr.String(), packet.FileName)
+		forDownloadFile.UpsertNode(udpAddr, packet.Node.Bitfield)
+	case protocol.NodeUpdated:
+		forDownloadFile.UpsertNode(udpAddr, packet.Node.Bitfield)
+	case protocol.NodeLeft:
+		logger.Info("Node %s left file %s", udpAddr.String(), packet.FileName)
+		forDownloadFile.RemoveNode(udpAddr.String())
+	default:
+		logger.Warn("Unknown node update packet type: %v", packet.Type)
+	}
+}
+
+// Resolves the domain name of a node, given by the tracker, to its UDP address
+func (n *Node) resolveNodeAddr(name string, port uint16) (*net.UDPAddr, error) {
+	ipAddrStr, err := n.dns.ResolveIP(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAddr, err := net.ResolveUDPAddr("udp4", ipAddrStr+":"+strconv.Itoa(int(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	return ipAddr, nil
+}
+
 // Handler for when a node publishes/removes a file in/from the network
 func (n *Node) handleFileSuccessPacket(packet *protocol.FileSuccessPacket, conn *transport.TCPConnection) {
 	switch packet.Type {
@@ -127,14 +224,33 @@ func (n *Node) handleFileSuccessPacket(packet *protocol.FileSuccessPacket, conn
 		logger.Info("File %s published in the network successfully", packet.FileName)
 
 		// Remove file from pending and add it to published, since tracker has accepted it
-		file, _ := n.pending.Get(packet.FileName)
+		n.pending.Lock()
+		file, ok := n.pending.M[packet.FileName]
+		delete(n.pending.M, packet.FileName)
+		n.pending.Unlock()
+
+		if !ok {
+			return
+		}
 		n.published.Put(packet.FileName, file)
-		n.pending.Delete(packet.FileName)
+
+		// What was published is no longer on disk, so it is withdrawn or republished like any other changed file
+		if file.ChangedWhilePending {
+			n.handleChangedFile(file, file.PendingChangePolicy)
+		}
 	case protocol.RemoveFileType:
 		logger.Info("File %s removed from the network successfully", packet.FileName)
 
 		// Remove file from published, since tracker has removed it from the network
-		n.published.Delete(packet.FileName)
+		n.published.Lock()
+		file, ok := n.published.M[packet.FileName]
+		delete(n.published.M, packet.FileName)
+		n.published.Unlock()
+
+		// Its open handles and cached chunks are no longer needed to seed it
+		if ok {
+			n.forgetFileContent(file)
+		}
 	default:
 		logger.Warn("Unknown file success packet type: %v", packet.Type)
 	}
@@ -148,6 +264,14 @@ func (n *Node) handleAlreadyExistsPacket(packet *protocol.AlreadyExistsPacket, c
 	n.pending.Delete(packet.Filename)
 }
 
+// Handler for when the tracker can not use the hashes of the file the node is trying to publish
+func (n *Node) handleInvalidPublishPacket(packet *protocol.InvalidPublishPacket, conn *transport.TCPConnection) {
+	logger.Error("File %s was not published: %s", packet.FileName, packet.Reason)
+
+	// Remove file from pending, since tracker has rejected it
+	n.pending.Delete(packet.FileName)
+}
+
 // Handler for when the file, the node is trying to download, does not exist in the network
 func (n *Node) handleNotFoundPacket(packet *protocol.NotFoundPacket, conn *transport.TCPConnection) {
 	logger.Info("File %s was not found in the network", packet.Filename)
@@ -170,33 +294,49 @@ func (n *Node) handleChunkPacket(packet *protocol.ChunkPacket, addr *net.UDPAddr
 	}
 
 	// Discard packet if hash of chunk is not correct
-	if forDownloadFile.GetChunkHash(packet.Chunk) != utils.HashChunk(packet.ChunkContent) {
+	if !forDownloadFile.VerifyChunk(packet.Chunk, packet.ChunkContent, packet.Proof) {
 		logger.Warn("Received incorrect hash of chunk %d of file %s", packet.Chunk, packet.FileName)
 		return
 	}
 
 	nodeInfo, ok := forDownloadFile.Nodes.Get(addr.String())
-	if !ok {
-		logger.Warn("Node %s sent unrequested chunk from file %s", addr, packet.FileName)
-	} else {
-		requested, b := nodeInfo.GetLastTimeChunkWasRequested(packet.Chunk)
-		if b && requested != (time.Time{}) {
-			n.nodeStatistics.addDownloadedChunk(addr.String(), uint64(len(packet.ChunkContent)), requested, time.Now())
+	if ok {
+		// The node serves us again, even if its Unchoke packet was lost
+		nodeInfo.Choked.Store(false)
+	}
+
+	wantedChunks := float64(forDownloadFile.NumberOfWantedChunks())
+	downloadedChunksSize := wantedChunks - float64(forDownloadFile.LengthOfMissingChunks())
+	percentage := downloadedChunksSize / wantedChunks * 100
+	newPercentage := (downloadedChunksSize + 1) / wantedChunks * 100
+
+	// Write chunk to file, or drop it to be requested again if the disk can not keep up
+	err := forDownloadFile.WriteChunkToDisk(packet.Chunk, packet.ChunkContent)
+	if err != nil {
+		logger.Warn("Dropped chunk %d of file %s: %v", packet.Chunk, packet.FileName, err)
+
+		// Our disk is the bottleneck, not the node, so its window is left as is
+		forDownloadFile.PendingChunks.Delete(packet.Chunk)
+		if ok {
+			nodeInfo.Pending.Delete(packet.Chunk)
 		}
+		return
 	}
 
-	downloadedChunksSize := float64(int(forDownloadFile.NumberOfChunks) - forDownloadFile.LengthOfMissingChunks())
-	percentage := downloadedChunksSize / float64(forDownloadFile.NumberOfChunks) * 100
-	newPercentage := (downloadedChunksSize + 1) / float64(forDownloadFile.NumberOfChunks) * 100
+	// Copies of the chunk requested from other nodes are no longer needed, now that it is written
+	n.cancelDuplicates(forDownloadFile, packet.Chunk, addr.String())
+
+	if !ok {
+		logger.Warn("Node %s sent unrequested chunk from file %s", addr, packet.FileName)
+	} else if requested, ok := nodeInfo.ReceiveChunk(packet.Chunk); ok {
+		n.nodeStatistics.addDownloadedChunk(addr.String(), uint64(len(packet.ChunkContent)), requested, time.Now())
+	}
 
 	const AnouncePercentageInterval = 10
 
 	if int(newPercentage/AnouncePercentageInterval) != int(percentage/AnouncePercentageInterval) {
 		logger.Info("File %s download progress: (%.1f%%)", packet.FileName, newPercentage)
 	}
-
-	// Write chunk to file
-	forDownloadFile.WriteChunkToDisk(packet.Chunk, packet.ChunkContent)
 }
 
 func (n *Node) handleRequestChunksPacket(packet *protocol.RequestChunksPacket, addr *net.UDPAddr) {
@@ -204,57 +344,212 @@ func (n *Node) handleRequestChunksPacket(packet *protocol.RequestChunksPacket, a
 
 	// Get file from published files
 	publishedFile, ok := n.published.Get(packet.FileName)
-	if !ok {
-		logger.Warn("File %s not found in published files", packet.FileName)
-
-		downloadFile, ok := n.forDownload.Get(packet.FileName)
-		if !ok {
-			logger.Warn("File %s not found in forDownload files", packet.FileName)
+	if ok && n.sharesPublished(packet.FileName) {
+		numberOfChunks := uint16(utils.NumberOfChunks(uint64(publishedFile.Size)))
+		if unavailable := unavailableChunks(packet.Chunks, numberOfChunks, nil); len(unavailable) > 0 {
+			n.rejectRequest(packet.FileName, protocol.RejectUnavailableChunks, unavailable, addr)
 			return
 		}
 
-		file := NewFile(packet.FileName, downloadFile.FilePath)
-		n.sendFileChunks(&file, packet, addr)
+		n.sendFileChunks(publishedFile, packet, addr, publishedFile.GetProof, true)
+		return
+	}
 
+	// Chunks already downloaded are served while the rest of the file is still being downloaded
+	downloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !downloadFile.UpdatedByTracker || !n.sharesDownload(packet.FileName) {
+		logger.Warn("File %s requested by %s is not shared", packet.FileName, addr)
+		n.rejectRequest(packet.FileName, protocol.RejectNotShared, packet.Chunks, addr)
 		return
 	}
 
-	n.sendFileChunks(publishedFile, packet, addr)
+	if unavailable := unavailableChunks(packet.Chunks, downloadFile.NumberOfChunks, downloadFile.ChunkAlreadyDownloaded); len(unavailable) > 0 {
+		n.rejectRequest(packet.FileName, protocol.RejectUnavailableChunks, unavailable, addr)
+		return
+	}
+
+	file := NewFile(packet.FileName, downloadFile.PartPath, downloadFile.HashAlgorithm, downloadFile.FileHash, downloadFile.Storage)
+	file.Size = int64(downloadFile.FileSize)
+	n.sendFileChunks(&file, packet, addr, downloadFile.GetChunkProof, false)
 }
 
-func (n *Node) sendFileChunks(publishedFile *File, packet *protocol.RequestChunksPacket, addr *net.UDPAddr) {
-	// Open file by the given path
-	file, err := os.Open(publishedFile.Path)
-	if err != nil {
-		logger.Warn("Error opening file: %v", err)
+func (n *Node) rejectRequest(fileName string, reason uint8, chunks []uint16, addr *net.UDPAddr) {
+	packet := protocol.NewRejectPacket(fileName, reason, chunks)
+	n.srv.SendPacket(&packet, addr)
+}
+
+// Chunks are only cached if the file is not expected to change, unlike a file still being downloaded
+func (n *Node) sendFileChunks(publishedFile *File, packet *protocol.RequestChunksPacket, addr *net.UDPAddr, getProof func(chunkIndex uint16) [][]byte, cached bool) {
+	if !n.choker.RequestUpload(addr.String(), packet.FileName) {
+		// Remind the node it is choked, in case it missed it
+		choke := protocol.NewChokePacket(packet.FileName)
+		n.srv.SendPacket(&choke, addr)
+		return
+	}
+
+	// Never serve content that no longer matches what was published
+	if publishedFile.HasChanged() {
+		n.handleChangedFile(publishedFile, n.changePolicy)
 		return
 	}
 
-	stats, _ := file.Stat()
-	chunkSize := utils.ChunkSize(uint64(stats.Size()))
+	chunkSize := utils.ChunkSize(uint64(publishedFile.Size))
+	n.clearCancelled(addr.String(), packet.FileName, packet.Chunks)
 
 	// Send requested chunks
 	for _, chunk := range packet.Chunks {
+		if n.takeCancelled(addr.String(), packet.FileName, chunk) {
+			continue // Received from another node meanwhile
+		}
+
 		logger.Info("Sending chunk %d of file %s to %s", chunk, packet.FileName, addr)
 
-		// Seek to the beginning of the chunk
-		_, err = file.Seek(int64(uint64(chunk)*chunkSize), 0)
-		if err != nil {
-			logger.Warn("Error seeking file: %v", err)
+		chunkContent, release, err := n.readChunk(publishedFile, chunk, chunkSize, cached)
+		if errors.Is(err, errCorruptedChunk) {
+			logger.Warn("Chunk %d of file %s does not match its hash on disk", chunk, packet.FileName)
+			n.handleChangedFile(publishedFile, n.changePolicy)
 			return
 		}
-
-		// Read chunk bytes
-		chunkContent := make([]byte, chunkSize)
-		read, err := file.Read(chunkContent)
-		if err != nil && !errors.Is(err, io.EOF) {
+		if err != nil {
 			logger.Warn("Error reading file: %v", err)
 			return
 		}
 
-		// Send chunk bytes
-		packet := protocol.NewChunkPacket(packet.FileName, chunk, chunkContent[:read])
+		// Send chunk bytes, which are serialized before returning
+		packet := protocol.NewChunkPacket(packet.FileName, chunk, chunkContent, getProof(chunk))
 		n.srv.SendPacket(&packet, addr)
-		n.nodeStatistics.addUploadedBytes(chunkSize)
+		n.nodeStatistics.addUploadedBytes(uint64(len(chunkContent)))
+		release()
+	}
+}
+
+// Handler for when a node on the same subnet announces the files it is seeding
+func (n *Node) handleAnnouncePacket(packet *protocol.AnnouncePacket, addr *net.UDPAddr) {
+	seeded := make(map[string]struct{}, len(packet.FileHashes))
+	for _, fileHash := range packet.FileHashes {
+		seeded[string(fileHash)] = struct{}{}
+	}
+
+	// Announcements are sent from an ephemeral port, so use the announced one instead
+	nodeAddr := net.UDPAddr{
+		IP:   addr.IP,
+		Port: int(packet.UDPPort),
+	}
+
+	if n.isSelf(&nodeAddr) {
+		return // Do not add itself to the list of nodes
+	}
+
+	n.forDownload.ForEach(func(fileName string, file *ForDownloadFile) {
+		if !file.UpdatedByTracker {
+			return // File hash is not known yet
+		}
+
+		if _, ok := seeded[string(file.FileHash)]; ok {
+			if !file.Nodes.Contains(nodeAddr.String()) {
+				logger.Info("Discovered node %s seeding file %s", nodeAddr.String(), fileName)
+			}
+
+			file.UpsertNode(&nodeAddr, protocol.NewCheckedBitfield(int(file.NumberOfChunks)))
+		}
+	})
+}
+
+// Handler for when a node, exchanging a file with us, shares the nodes it knows about
+func (n *Node) handlePeerExchangePacket(packet *protocol.PeerExchangePacket, addr *net.UDPAddr) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return // Only nodes downloading the file care about its peers
+	}
+
+	// The sender itself may have chunks we are missing
+	forDownloadFile.UpsertNode(addr, packet.Bitfield)
+
+	for _, peer := range packet.Peers {
+		peerAddr := utils.BytesAndPortToUDPAddr(peer.IPAddr, peer.Port)
+		if n.isSelf(peerAddr) {
+			continue // Do not add itself to the list of nodes
+		}
+
+		if forDownloadFile.AddNodeIfAbsent(peerAddr, peer.Bitfield) {
+			logger.Info("Discovered node %s for file %s through %s", peerAddr.String(), packet.FileName, addr.String())
+		}
+	}
+
+	if packet.Type == protocol.PeerExchangeRequest {
+		answer := n.newPeerExchangePacket(forDownloadFile, protocol.PeerExchangeAnswer, addr.String())
+		n.srv.SendPacket(&answer, addr)
+	}
+}
+
+// Handler for when a node, exchanging a file with us, has finished downloading new chunks of it
+func (n *Node) handleHavePacket(packet *protocol.HavePacket, addr *net.UDPAddr) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return // Only nodes downloading the file care about its chunks
+	}
+
+	// Chunks become requestable right away, instead of waiting for the tracker
+	forDownloadFile.AddNodeChunks(addr, packet.Chunks)
+}
+
+// Handler for when a node, we are downloading a file from, has no upload slot for us
+func (n *Node) handleChokePacket(packet *protocol.ChokePacket, addr *net.UDPAddr) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return
+	}
+
+	nodeInfo, ok := forDownloadFile.Nodes.Get(addr.String())
+	if !ok {
+		return
+	}
+	nodeInfo.Choked.Store(true)
+
+	// Requests in flight will not be answered, so they are requested from other nodes right away instead of timing out
+	for _, chunkIndex := range nodeInfo.Pending.Keys() {
+		nodeInfo.Pending.Delete(chunkIndex)
+		forDownloadFile.PendingChunks.Delete(chunkIndex)
+	}
+}
+
+// Handler for when a node, we are downloading a file from, has an upload slot for us again
+func (n *Node) handleUnchokePacket(packet *protocol.UnchokePacket, addr *net.UDPAddr) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return
+	}
+
+	if nodeInfo, ok := forDownloadFile.Nodes.Get(addr.String()); ok {
+		nodeInfo.Choked.Store(false)
+	}
+}
+
+// Handler for when a node, we are downloading a file from, does not offer the chunks we requested
+func (n *Node) handleRejectPacket(packet *protocol.RejectPacket, addr *net.UDPAddr) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return
+	}
+
+	nodeInfo, ok := forDownloadFile.Nodes.Get(addr.String())
+	if !ok {
+		return
+	}
+
+	// Requested chunks are requested from other nodes right away, instead of waiting for them to time out
+	for _, chunkIndex := range packet.Chunks {
+		forDownloadFile.PendingChunks.Delete(chunkIndex)
+		nodeInfo.Pending.Delete(chunkIndex)
+	}
+
+	if packet.Reason == protocol.RejectNotShared {
+		logger.Info("Node %s does not share file %s, removing it", addr, packet.FileName)
+		forDownloadFile.RemoveNode(addr.String())
+		return
+	}
+
+	for _, chunkIndex := range packet.Chunks {
+		forDownloadFile.RemoveNodeChunk(nodeInfo, chunkIndex)
 	}
 }
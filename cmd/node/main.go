@@ -3,8 +3,13 @@ package main
 import (
 	"PessiTorrent/internal/config"
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/selection"
+	"PessiTorrent/internal/storage"
+	"PessiTorrent/internal/utils"
 	"flag"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 func main() {
@@ -18,10 +23,150 @@ func main() {
 	trackerAddr := cfg.Tracker.Host + ":" + strconv.Itoa(int(cfg.Tracker.Port))
 	udpPort := cfg.Node.Port
 
+	merkleTree := cfg.Node.MerkleTree
+
+	uploadLimits := Limits{Global: cfg.Node.Limits.Upload, PerPeer: cfg.Node.Limits.PeerUpload, PerFile: cfg.Node.Limits.FileUpload}
+	downloadLimits := Limits{Global: cfg.Node.Limits.Download, PerPeer: cfg.Node.Limits.PeerDownload, PerFile: cfg.Node.Limits.FileDownload}
+	preallocate := cfg.Node.Preallocate
+
+	hashAlgorithmName := utils.HashAlgorithmName(utils.DefaultHashAlgorithm)
+	if cfg.Node.HashAlgorithm != "" {
+		hashAlgorithmName = cfg.Node.HashAlgorithm
+	}
+
+	conflictPolicyName := ConflictPolicyName(RenameOnConflict)
+	if cfg.Node.OnConflict != "" {
+		conflictPolicyName = cfg.Node.OnConflict
+	}
+
+	changePolicyName := ChangePolicyName(WithdrawOnChange)
+	if cfg.Node.OnChange != "" {
+		changePolicyName = cfg.Node.OnChange
+	}
+
+	sharingPolicyName := SharingPolicyName(SeedSharing)
+	if cfg.Node.Sharing != "" {
+		sharingPolicyName = cfg.Node.Sharing
+	}
+
+	strategyName := selection.StrategyName(selection.RarestFirstStrategy)
+	if cfg.Node.Selection != "" {
+		strategyName = cfg.Node.Selection
+	}
+
+	watchDirectories := strings.Join(cfg.Node.Watch, ",")
+
+	storageBackendName := storage.BackendName(storage.LocalBackend)
+	if cfg.Node.Storage != "" {
+		storageBackendName = cfg.Node.Storage
+	}
+
+	discoveryAddr := ""
+	if cfg.Node.Discovery.Enabled {
+		discoveryAddr = cfg.Node.Discovery.Address
+	}
+
+	maxDownloads := cfg.Node.MaxDownloads
+
+	streamAddr := ""
+	if cfg.Node.Stream.Enabled {
+		streamAddr = cfg.Node.Stream.Address
+	}
+
 	flag.StringVar(&trackerAddr, "t", trackerAddr, "Tracker address")
 	flag.UintVar(&udpPort, "p", udpPort, "Node UDP port")
+	flag.StringVar(&discoveryAddr, "m", discoveryAddr, "Multicast group address for LAN discovery (disabled if empty)")
+	flag.StringVar(&streamAddr, "stream", streamAddr, "HTTP address files are streamed on (disabled if empty)")
+	flag.BoolVar(&merkleTree, "merkle", merkleTree, "Publish files with a Merkle tree instead of every chunk hash")
+	flag.StringVar(&hashAlgorithmName, "hash", hashAlgorithmName, "Hash algorithm to publish files with (sha1, sha256 or blake3)")
+	flag.StringVar(&conflictPolicyName, "conflict", conflictPolicyName, "What to do when a downloaded file already exists (rename, skip or overwrite)")
+	flag.StringVar(&changePolicyName, "change", changePolicyName, "What to do when a published file changes on disk (withdraw or republish)")
+	flag.StringVar(&sharingPolicyName, "sharing", sharingPolicyName, "Who downloaded files are served to (seed, leech-only or private)")
+	flag.StringVar(&strategyName, "selection", strategyName, "Order the chunks of downloads are requested in (rarest-first, sequential, random-first or priority)")
+	flag.StringVar(&watchDirectories, "w", watchDirectories, "Comma separated directories whose files are automatically published")
+	flag.StringVar(&storageBackendName, "storage", storageBackendName, "Where downloaded files are stored (local or content-addressed)")
+	flag.IntVar(&maxDownloads, "max-downloads", maxDownloads, "Downloads active at once (0 for unlimited)")
+	flag.BoolVar(&preallocate, "preallocate", preallocate, "Reserve the whole space of a file before downloading it")
+	flag.Uint64Var(&uploadLimits.Global, "up", uploadLimits.Global, "Upload limit in bytes per second (0 for unlimited)")
+	flag.Uint64Var(&downloadLimits.Global, "down", downloadLimits.Global, "Download limit in bytes per second (0 for unlimited)")
 	flag.Parse()
 
-	node := NewNode(trackerAddr, uint16(udpPort), dns)
+	hashAlgorithm, err := utils.ParseHashAlgorithm(hashAlgorithmName)
+	if err != nil {
+		logger.Error("Invalid hash algorithm: %s", err)
+		return
+	}
+
+	conflictPolicy, err := ParseConflictPolicy(conflictPolicyName)
+	if err != nil {
+		logger.Error("Invalid conflict policy: %s", err)
+		return
+	}
+
+	changePolicy, err := ParseChangePolicy(changePolicyName)
+	if err != nil {
+		logger.Error("Invalid change policy: %s", err)
+		return
+	}
+
+	sharingPolicy, err := ParseSharingPolicy(sharingPolicyName)
+	if err != nil {
+		logger.Error("Invalid sharing policy: %s", err)
+		return
+	}
+
+	strategy, err := selection.ParseStrategy(strategyName)
+	if err != nil {
+		logger.Error("Invalid selection strategy: %s", err)
+		return
+	}
+
+	storageBackend, err := storage.ParseBackend(storageBackendName)
+	if err != nil {
+		logger.Error("Invalid storage backend: %s", err)
+		return
+	}
+
+	var downloadStorage storage.Storage // Local filesystem by default
+	if storageBackend == storage.ContentAddressBackend {
+		downloadStorage, err = storage.NewContentAddressedStorage(filepath.Join(DefaultDownloadDirectory, ContentAddressedDirectory))
+		if err != nil {
+			logger.Error("Failed to create content addressed storage: %s", err)
+			return
+		}
+	}
+
+	node := NewNode(trackerAddr, uint16(udpPort), dns, Options{
+		DiscoveryAddr:  discoveryAddr,
+		StreamAddr:     streamAddr,
+		MerkleTree:     merkleTree,
+		HashAlgorithm:  hashAlgorithm,
+		ConflictPolicy: conflictPolicy,
+		ChangePolicy:   changePolicy,
+		SharingPolicy:  sharingPolicy,
+		Strategy:       strategy,
+
+		MaxActiveDownloads: max(0, maxDownloads),
+
+		WatchDirectories: splitList(watchDirectories),
+
+		Storage:     downloadStorage,
+		Preallocate: preallocate,
+
+		UploadLimits:   uploadLimits,
+		DownloadLimits: downloadLimits,
+	})
 	node.Start()
 }
+
+// Splits a comma separated list, ignoring empty items
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
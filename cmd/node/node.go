@@ -4,26 +4,70 @@ import (
 	"PessiTorrent/internal/cli"
 	"PessiTorrent/internal/dns"
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/merkle"
 	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/ratelimit"
+	"PessiTorrent/internal/storage"
 	"PessiTorrent/internal/structures"
 	"PessiTorrent/internal/ticker"
 	"PessiTorrent/internal/transport"
 	"PessiTorrent/internal/utils"
+	"PessiTorrent/internal/watcher"
+	"math/rand"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"sort"
 	"time"
 )
 
 const (
 	UpdateServerChunksInterval  = 5 * time.Second
-	MaxChunksPerRequest         = 100
-	ChunkRequestTimeoutDuration = 500 * time.Millisecond
+	MaxChunksPerRequest         = 100 // Chunks in a single request, the node's window may allow more over several
 	MaxTriesPerChunk            = 3
 	MaxNodeTimeouts             = 3
 	TickInterval                = 100 * time.Millisecond
+	DiscoveryAnnounceInterval   = 5 * time.Second
+	PeerExchangeInterval        = 2 * time.Second
+	SaveDownloadStateInterval   = 5 * time.Second
+	CheckPublishedFilesInterval = 10 * time.Second
+	WatchInterval               = 2 * time.Second
+	ChunkCacheCapacity          = 64 * 1024 * 1024 // bytes
+	DiskFullRetryInterval       = 30 * time.Second
 	DefaultDownloadDirectory    = "downloads"
+	ContentAddressedDirectory   = ".chunks" // Inside the default download directory
 )
 
+// Options holds the optional behaviour of a node, as read from the config file and flags
+type Options struct {
+	DiscoveryAddr  string // Multicast group used for LAN discovery, disabled if empty
+	StreamAddr     string // HTTP address files are streamed on, disabled if empty
+	MerkleTree     bool   // Whether files are published with a Merkle tree instead of every chunk hash
+	HashAlgorithm  uint8  // Hash algorithm files are published with
+	ConflictPolicy uint8  // What to do when the destination of a download already exists
+	ChangePolicy   uint8  // What to do when a published file is modified or deleted on disk
+	SharingPolicy  uint8  // Who downloaded files are served to, unless set for a file
+	Strategy       uint8  // Order the chunks of downloads are requested in
+
+	MaxActiveDownloads int // Downloads started at once, zero for unlimited
+
+	WatchDirectories []string // Directories whose files are automatically published
+
+	Storage     storage.Storage // Where downloaded files are stored, on the local filesystem if nil
+	Preallocate bool            // Whether the whole space of a file is reserved before downloading it
+
+	UploadLimits   Limits
+	DownloadLimits Limits
+}
+
+// Limits holds the rates, in bytes per second, a node transfers chunks at. Zero means unlimited
+type Limits struct {
+	Global  uint64
+	PerPeer uint64
+	PerFile uint64
+}
+
 type Node struct {
 	dns *dns.DNS
 
@@ -35,30 +79,105 @@ type Node struct {
 	srv  transport.UDPServer
 	tck  ticker.Ticker
 
+	checkTck ticker.Ticker // Periodically checks published files for changes on disk
+
+	choker   *Choker
+	chokeTck ticker.Ticker
+
+	watcher          *watcher.Watcher
+	watchDirectories []string
+
+	storage      storage.Storage                        // Where downloaded files are written to and seeded from
+	localStorage *storage.LocalStorage                  // Where published files are seeded from
+	chunkCache   *structures.LRUCache[chunkKey, []byte] // Most recently served chunks
+
+	discoveryAddr string
+	discovering   bool
+	mcast         transport.MulticastServer
+	discoveryTck  ticker.Ticker
+
+	streamAddr   string
+	streamServer *http.Server // Nil unless streaming, set before the CLI starts
+
 	published      structures.SynchronizedMap[string, *File]
 	pending        structures.SynchronizedMap[string, *File]
 	forDownload    structures.SynchronizedMap[string, *ForDownloadFile]
+	queue          *DownloadQueue
+	queueTck       ticker.Ticker
 	downloadedFile structures.SynchronizedMap[string, *File]
 
 	downloadDirectory string
+	conflictPolicy    uint8
+	preallocate       bool
+	failedDownloads   structures.SynchronizedMap[string, error] // File name -> Why its download failed
+	changePolicy      uint8
+	defaultSharing    uint8                                     // Sharing policy of downloads
+	sharing           structures.SynchronizedMap[string, uint8] // File name -> Sharing policy set for the file
+	defaultStrategy   uint8                                     // Chunk selection strategy of new downloads
+
+	cancelled structures.SynchronizedMap[cancelKey, time.Time] // Chunks nodes no longer want from us -> When they were cancelled
+
+	merkleTree    bool
+	hashAlgorithm uint8
+
+	uploadLimits   *ratelimit.Limits
+	downloadLimits *ratelimit.Limits
 
 	nodeStatistics *NodeStatistics
 
 	quitChannel chan struct{}
 }
 
-func NewNode(trackerAddr string, udpPort uint16, dnsAddr string) Node {
+func NewNode(trackerAddr string, udpPort uint16, dnsAddr string, options Options) Node {
+	localStorage := storage.NewLocalStorage()
+
+	downloadStorage := options.Storage
+	if downloadStorage == nil {
+		downloadStorage = localStorage
+	}
+
 	return Node{
 		dns: dns.NewDNS(dnsAddr),
 
 		trackerAddr: trackerAddr,
 		udpPort:     udpPort,
 
+		discoveryAddr: options.DiscoveryAddr,
+		streamAddr:    options.StreamAddr,
+
 		pending:     structures.NewSynchronizedMap[string, *File](),
 		published:   structures.NewSynchronizedMap[string, *File](),
 		forDownload: structures.NewSynchronizedMap[string, *ForDownloadFile](),
+		queue:       NewDownloadQueue(options.MaxActiveDownloads, filepath.Join(DefaultDownloadDirectory, QueueFile)),
+
+		downloadedFile: structures.NewSynchronizedMap[string, *File](),
 
 		downloadDirectory: DefaultDownloadDirectory,
+		conflictPolicy:    options.ConflictPolicy,
+		preallocate:       options.Preallocate,
+		failedDownloads:   structures.NewSynchronizedMap[string, error](),
+		changePolicy:      options.ChangePolicy,
+		defaultSharing:    options.SharingPolicy,
+		sharing:           structures.NewSynchronizedMap[string, uint8](),
+		defaultStrategy:   options.Strategy,
+
+		cancelled: structures.NewSynchronizedMap[cancelKey, time.Time](),
+
+		watchDirectories: options.WatchDirectories,
+
+		storage:      downloadStorage,
+		localStorage: localStorage,
+		chunkCache: structures.NewLRUCache[chunkKey, []byte](ChunkCacheCapacity, func(chunkContent []byte) int64 {
+			return int64(len(chunkContent))
+		}),
+
+		merkleTree:    options.MerkleTree,
+		hashAlgorithm: options.HashAlgorithm,
+
+		uploadLimits:   ratelimit.NewLimits(options.UploadLimits.Global, options.UploadLimits.PerPeer, options.UploadLimits.PerFile),
+		downloadLimits: ratelimit.NewLimits(options.DownloadLimits.Global, options.DownloadLimits.PerPeer, options.DownloadLimits.PerFile),
+
+		choker: NewChoker(),
 
 		nodeStatistics: NewNodeStatistics(),
 
@@ -67,11 +186,24 @@ func NewNode(trackerAddr string, udpPort uint16, dnsAddr string) Node {
 }
 
 func (n *Node) Start() {
+	// Downloads of a previous run are started once connected to the tracker
+	if err := n.queue.load(); err != nil {
+		logger.Warn("Error loading download queue: %v", err)
+	}
+
+	n.startWatcher() // Before the CLI, which uses the watcher
+	if n.streamAddr != "" {
+		n.startStreaming() // Before the CLI, which may stop the server
+	}
 	go n.startTCP()
 	go n.startUDP()
 	go n.startCLI()
 	go n.startTicker()
 
+	if n.discoveryAddr != "" {
+		go n.startDiscovery()
+	}
+
 	<-n.quitChannel
 }
 
@@ -113,6 +245,7 @@ func (n *Node) startUDP() {
 	}
 
 	n.srv = transport.NewUDPServer(*conn, n.HandleUDPPackets, func() {})
+	n.srv.SetThrottle(n.throttleUploads)
 	go n.srv.Start()
 
 	logger.Info("UDP server started on %s", udpAddr.String())
@@ -127,29 +260,231 @@ func (n *Node) startCLI() {
 	c.AddCommand("connect", "<tracker address>", "Connect to the tracker", 1, n.connect)
 	c.AddCommand("publish", "<file name | directory>", "", 1, n.publish)
 	c.AddCommand("request", "<file name>", "", 1, n.requestFile)
+	c.AddCommand("request-priority", "<file name> <priority>", "Queue a file, downloads with higher priorities start first", 2, n.requestFileWithPriority)
+	c.AddCommand("request-range", "<file name> <bytes | chunks> <first>-<last>", "Queue only the chunks covering a range of a file", 3, n.requestFileRange)
+	c.AddCommand("queue", "", "Show the queued downloads", 0, n.showQueue)
+	c.AddCommand("pause", "<file name>", "Pause a download, freeing its slot", 1, n.pauseDownload)
+	c.AddCommand("resume", "<file name>", "Resume a paused download once there is a free slot", 1, n.resumeDownload)
+	c.AddCommand("cancel", "<file name>", "Cancel a download, deleting what was downloaded", 1, n.cancelDownload)
+	c.AddCommand("set-download-priority", "<file name> <priority>", "Set the priority of a queued download", 2, n.setDownloadPriority)
+	c.AddCommand("set-max-downloads", "<number of downloads>", "Set how many downloads are active at once, 0 for unlimited", 1, n.setMaxDownloads)
+	c.AddCommand("stream", "<file name>", "Download a file in order to play it while it is downloaded", 1, n.streamFile)
 	c.AddCommand("status", "", "Show the status of the node", 0, n.status)
 	c.AddCommand("statistics", "", "Show the statistics of the node", 0, n.statistics)
 	c.AddCommand("set-downloads", "<directory>", "Set download directory path", 1, n.setDownloadDirectory)
+	c.AddCommand("set-conflict", "<rename | skip | overwrite>", "Set what to do when a downloaded file already exists", 1, n.setConflictPolicy)
+	c.AddCommand("set-change", "<withdraw | republish>", "Set what to do when a published file changes on disk", 1, n.setChangePolicy)
+	c.AddCommand("set-sharing", "<file name> <seed | leech-only | private>", "Set who a file is served to", 2, n.setSharing)
+	c.AddCommand("set-default-sharing", "<seed | leech-only | private>", "Set who downloaded files are served to", 1, n.setDefaultSharing)
+	c.AddCommand("set-selection", "<file name> <rarest-first | sequential | random-first | priority>", "Set the order the chunks of a download are requested in", 2, n.setSelection)
+	c.AddCommand("set-default-selection", "<rarest-first | sequential | random-first | priority>", "Set the order the chunks of new downloads are requested in", 1, n.setDefaultSelection)
+	c.AddCommand("set-priority", "<file name> <first chunk> <last chunk> <priority>", "Set the priority of chunks of a download with priority selection", 4, n.setPriority)
 	c.AddCommand("remove", "<file name>", "", 1, n.removeFile)
+	c.AddCommand("set-limit", "<upload | download> <bytes per second>", "Set the global transfer limit, 0 for unlimited", 2, n.setLimit)
+	c.AddCommand("set-peer-limit", "<upload | download> <bytes per second>", "Set the transfer limit of each peer, 0 for unlimited", 2, n.setPeerLimit)
+	c.AddCommand("set-file-limit", "<upload | download> <file name> <bytes per second>", "Set the transfer limit of a file, 0 for unlimited", 3, n.setFileLimit)
+	c.AddCommand("watch", "<directory>", "Automatically publish the files of a directory", 1, n.watch)
+	c.AddCommand("unwatch", "<directory>", "Stop watching a directory, keeping its files published", 1, n.unwatch)
 	c.Start()
 }
 
+func (n *Node) startWatcher() {
+	n.watcher = watcher.NewWatcher(WatchInterval, n.handleWatchEvent)
+
+	for _, directory := range n.watchDirectories {
+		if err := n.watcher.Add(directory); err != nil {
+			logger.Error("Failed to watch directory %s: %s", directory, err)
+			continue
+		}
+
+		logger.Info("Watching directory %s", directory)
+	}
+
+	n.watcher.Start()
+}
+
+func (n *Node) startDiscovery() {
+	groupAddr, err := net.ResolveUDPAddr("udp4", n.discoveryAddr)
+	if err != nil {
+		logger.Error("Invalid discovery address %s: %s", n.discoveryAddr, err)
+		return
+	}
+
+	listener, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		logger.Error("Failed to join multicast group %s: %s", groupAddr.String(), err)
+		return
+	}
+
+	sender, err := net.DialUDP("udp4", nil, groupAddr)
+	if err != nil {
+		listener.Close()
+		logger.Error("Failed to start multicast sender on %s: %s", groupAddr.String(), err)
+		return
+	}
+
+	n.mcast = transport.NewMulticastServer(listener, sender, n.HandleUDPPackets, func() {})
+	go n.mcast.Start()
+
+	tck := ticker.NewTicker(DiscoveryAnnounceInterval, n.announceFiles)
+	tck.Start()
+	n.discoveryTck = tck
+	n.discovering = true
+
+	logger.Info("LAN discovery started on %s", groupAddr.String())
+}
+
+// Multicasts the hashes of the files the node is seeding to the nodes on the same subnet
+func (n *Node) announceFiles() {
+	fileHashes := make([][]byte, 0)
+	n.published.ForEach(func(_ string, file *File) {
+		fileHashes = append(fileHashes, file.FileHash)
+	})
+
+	if len(fileHashes) == 0 {
+		return
+	}
+
+	packet := protocol.NewAnnouncePacket(n.udpPort, fileHashes)
+	n.mcast.SendPacket(&packet)
+}
+
 func (n *Node) startTicker() {
 	tck := ticker.NewTicker(TickInterval, n.tick)
 	tck.Start()
 	n.tck = tck
+
+	checkTck := ticker.NewTicker(CheckPublishedFilesInterval, n.checkPublishedFiles)
+	checkTck.Start()
+	n.checkTck = checkTck
+
+	chokeTck := ticker.NewTicker(RechokeInterval, n.rechoke)
+	chokeTck.Start()
+	n.chokeTck = chokeTck
+
+	queueTck := ticker.NewTicker(ScheduleDownloadsInterval, n.scheduleDownloads)
+	queueTck.Start()
+	n.queueTck = queueTck
 }
 
 func (n *Node) updateServerChunks(file *ForDownloadFile) {
-	bitfield := make([]bool, 0)
-	file.Chunks.ForEach(func(chunkInfo ChunkInfo) {
-		bitfield = append(bitfield, chunkInfo.Downloaded)
-	})
+	if !n.sharesDownload(file.FileName) {
+		return // Other nodes are not sent our way
+	}
 
-	encondedBitfield := protocol.EncodeBitField(bitfield)
+	chunks := file.UnreportedChunks.Drain()
 
-	packet := protocol.NewUpdateChunksPacket(file.FileName, encondedBitfield)
+	// The tracker only needs the full bitfield once, afterwards just the newly acquired chunks
+	if !file.ReportedToTracker {
+		file.ReportedToTracker = true
+
+		packet := protocol.NewUpdateChunksPacket(file.FileName, file.Bitfield())
+		n.conn.EnqueuePacket(&packet)
+		logger.Info("Sent update chunks packet to tracker for file %s", file.FileName)
+		return
+	}
+
+	if len(chunks) == 0 {
+		return
+	}
+
+	packet := protocol.NewUpdateChunksDeltaPacket(file.FileName, protocol.EncodeChunkRanges(chunks))
 	n.conn.EnqueuePacket(&packet)
+	logger.Info("Sent update chunks delta packet to tracker for file %s", file.FileName)
+}
+
+// Tells every node of the file which chunks we have downloaded since the last time
+func (n *Node) announceChunks(file *ForDownloadFile) {
+	chunks := file.UnannouncedChunks.Drain()
+	if len(chunks) == 0 || !n.sharesDownload(file.FileName) {
+		return
+	}
+
+	// A resumed download may announce every chunk at once, more than fit in a single packet
+	packets := protocol.NewHavePackets(file.FileName, chunks, transport.UDPMaxPacketSize)
+	for _, nodeInfo := range file.Nodes.Values() {
+		nodeAddr, err := net.ResolveUDPAddr("udp4", nodeInfo.Address)
+		if err != nil {
+			continue
+		}
+
+		for i := range packets {
+			n.srv.EnqueueRequest(&packets[i], nodeAddr)
+		}
+	}
+}
+
+// Tells the tracker and every node of the file that chunks we announced are no longer available.
+// Deltas and HAVE packets can only add chunks, so both are sent the whole bitfield again
+func (n *Node) retractChunks(file *ForDownloadFile) {
+	if !n.sharesDownload(file.FileName) {
+		return // Nobody was told about the chunks
+	}
+
+	file.ReportedToTracker = false
+	n.updateServerChunks(file)
+
+	// Nodes replace what they know about the sender with the bitfield of a peer exchange
+	packet := protocol.NewPeerExchangePacket(file.FileName, protocol.PeerExchangeAnswer, file.Bitfield(), make([]protocol.PeerFileInfo, 0))
+	for _, nodeInfo := range file.Nodes.Values() {
+		nodeAddr, err := net.ResolveUDPAddr("udp4", nodeInfo.Address)
+		if err != nil {
+			continue
+		}
+
+		n.srv.EnqueueRequest(&packet, nodeAddr)
+	}
+}
+
+// Shares, with every node of the file, our bitfield and the other nodes we know have chunks of it
+func (n *Node) exchangePeers(file *ForDownloadFile) {
+	for _, nodeInfo := range file.Nodes.Values() {
+		nodeAddr, err := net.ResolveUDPAddr("udp4", nodeInfo.Address)
+		if err != nil {
+			continue
+		}
+
+		packet := n.newPeerExchangePacket(file, protocol.PeerExchangeRequest, nodeInfo.Address)
+		n.srv.EnqueueRequest(&packet, nodeAddr)
+	}
+}
+
+func (n *Node) newPeerExchangePacket(file *ForDownloadFile, packetType uint8, exclude string) protocol.PeerExchangePacket {
+	peers := make([]protocol.PeerFileInfo, 0)
+	file.Nodes.ForEach(func(nodeAddrString string, nodeInfo *NodeInfo) {
+		if nodeAddrString == exclude {
+			return // Receiver already knows about itself
+		}
+
+		nodeAddr, err := net.ResolveUDPAddr("udp4", nodeAddrString)
+		if err != nil {
+			return
+		}
+
+		peers = append(peers, protocol.PeerFileInfo{
+			IPAddr:   utils.UDPAddrToBytes(nodeAddr),
+			Port:     uint16(nodeAddr.Port),
+			Bitfield: nodeInfo.Bitfield(file.NumberOfChunks),
+		})
+	})
+
+	// Nodes are not told about chunks they can not request from us
+	bitfield := file.Bitfield()
+	if !n.sharesDownload(file.FileName) {
+		bitfield = protocol.EncodeBitField(make([]bool, file.NumberOfChunks))
+	}
+
+	// Bitfields can be large, so only some peers may fit. Which ones changes on every exchange
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	return protocol.NewBoundedPeerExchangePacket(file.FileName, packetType, bitfield, peers, transport.UDPMaxPacketSize)
+}
+
+// Returns true if the given address is the node's own UDP address
+func (n *Node) isSelf(addr *net.UDPAddr) bool {
+	return int(n.udpPort) == addr.Port && utils.IsLocalIP(addr.IP)
 }
 
 func (n *Node) tick() {
@@ -161,75 +496,151 @@ func (n *Node) tick() {
 			continue
 		}
 
-		if time.Since(file.LastServerChunksUpdate) > UpdateServerChunksInterval || file.IsFileDownloaded() {
-			file.LastServerChunksUpdate = time.Now()
-			n.updateServerChunks(file)
-			logger.Info("Sent update chunks packet to tracker for file %s", fileName)
+		if file.IsFileDownloaded() && file.Wanted != nil {
+			// A range can not be checked against the hash of the whole file, but each of its chunks was verified when received or resumed
+			if !file.RangeDownloaded.Load() {
+				file.RangeDownloaded.Store(true)
+				logger.Info("Range %s of file %s was successfully downloaded to %s", file.Range, fileName, file.PartPath)
 
-			if !file.IsFileDownloaded() { // If file is downloaded, we don't need to update the nodes with the file
-				// Also request to update our nodes info about the file
-				packet := protocol.NewUpdateFilePacket(fileName)
-				n.conn.EnqueuePacket(&packet)
+				if err := file.SaveState(); err != nil {
+					logger.Warn("Error saving download state of file %s: %v", fileName, err)
+				}
+				n.updateServerChunks(file)
+				n.announceChunks(file)
 			}
+			continue
 		}
 
 		if file.IsFileDownloaded() {
-			timeToDownload := time.Since(file.DownloadStarted)
-			logger.Info("File %s was successfully downloaded in %s", fileName, timeToDownload.String())
-			file.FileWriter.Stop()
-
-			newFile := NewFile(file.FileName, file.FilePath)
-			n.published.Put(file.FileName, &newFile)
+			// Only announce the file as complete and seedable once it matches its hash
+			if !file.Verified {
+				if !file.Verifying {
+					file.Verifying = true
+					logger.Info("File %s was downloaded, verifying it against its hash", fileName)
+					go n.verifyDownloadedFile(fileName, file)
+				}
+				continue
+			}
 
-			delete(n.forDownload.M, fileName)
+			n.updateServerChunks(file)
+			n.announceChunks(file)
+			n.completeDownload(fileName, file)
 			continue
 		}
 
-		missingChunks := file.GetMissingChunks()
+		if time.Since(file.LastServerChunksUpdate) > UpdateServerChunksInterval {
+			file.LastServerChunksUpdate = time.Now()
+			n.updateServerChunks(file)
+		}
 
-		// Sort missing chunks by rarity
-		sort.Slice(missingChunks, func(i, j int) bool {
-			missingChunkI := uint16(missingChunks[i])
-			missingChunkJ := uint16(missingChunks[j])
+		if time.Since(file.LastStateSave) > SaveDownloadStateInterval {
+			file.LastStateSave = time.Now()
+			if err := file.SaveState(); err != nil {
+				logger.Warn("Error saving download state of file %s: %v", fileName, err)
+			}
+		}
 
-			return file.GetNumberOfNodesWhichHaveChunk(missingChunkI) < file.GetNumberOfNodesWhichHaveChunk(missingChunkJ)
-		})
+		n.announceChunks(file)
+
+		if time.Since(file.LastPeerExchange) > PeerExchangeInterval {
+			file.LastPeerExchange = time.Now()
+			n.exchangePeers(file)
+		}
+
+		// Nothing more is requested until there may be space to write it
+		if file.DiskFull.Load() {
+			if file.PausedAt.IsZero() {
+				file.PausedAt = time.Now()
+				logger.Error("Disk is full, pausing download of file %s", fileName)
+			}
+
+			if time.Since(file.PausedAt) < DiskFullRetryInterval {
+				continue
+			}
+
+			file.DiskFull.Store(false)
+			file.PausedAt = time.Time{}
+			logger.Info("Resuming download of file %s", fileName)
+		}
+
+		missingChunks := make([]uint16, 0)
+		for _, chunk := range file.GetMissingChunks() {
+			missingChunks = append(missingChunks, uint16(chunk))
+		}
+
+		// Chunks requested first are ordered first, following the strategy of the download
+		file.Selector.Order(missingChunks, file.NumberOfWantedChunks()-len(missingChunks))
 
 		nodes := file.Nodes.Values()
+		for _, nodeInfo := range nodes {
+			n.expireRequests(file, nodeInfo)
+		}
+
+		// Fastest nodes first, so they get the rarest chunks
 		sort.Slice(nodes, func(i, j int) bool {
-			return n.nodeStatistics.getAverageDownloadSpeed(nodes[i].Address) < n.nodeStatistics.getAverageDownloadSpeed(nodes[j].Address)
+			return n.nodeStatistics.getAverageDownloadSpeed(nodes[i].Address) > n.nodeStatistics.getAverageDownloadSpeed(nodes[j].Address)
 		})
 
+		// Close to completion, the last chunks are requested from several nodes, so a slow node does not hold back the file
+		endgame := len(missingChunks) <= EndgameThreshold
+		if endgame && !file.Endgame {
+			logger.Info("Entering endgame mode for file %s, %d chunks missing", fileName, len(missingChunks))
+		}
+		file.Endgame = endgame
+
+		// Chunk index -> Nodes it is requested from
+		requests := make(map[uint16]int)
+		if endgame {
+			requests = file.RequestsPerChunk()
+		}
+
 		chunksToRequest := make(map[*NodeInfo][]uint16)
+		assigned := make(map[uint16]struct{})
+
+		chunkSize := int(utils.ChunkSize(file.FileSize))
 
 		for _, nodeInfo := range nodes {
-			chunksToRequest[nodeInfo] = make([]uint16, 0)
+			if !file.Nodes.Contains(nodeInfo.Address) {
+				continue // Timed out too many times
+			}
 
-			for len(missingChunks) > 0 && len(chunksToRequest[nodeInfo]) < MaxChunksPerRequest {
-				chunk := missingChunks[0]
-				missingChunks = missingChunks[1:] // Pop first element
+			// Only as many chunks as fit in the node's window, and as the download limits allow to arrive
+			maxChunks := min(nodeInfo.Window.Available(nodeInfo.Pending.Len()), MaxChunksPerRequest)
+			maxChunks = n.downloadLimits.Available(nodeInfo.Address, file.FileName, maxChunks, chunkSize)
 
-				requestInfo, hasChunk := nodeInfo.Chunks.Get(uint16(chunk))
-				if !hasChunk {
+			if nodeInfo.Choked.Load() {
+				// A node without an upload slot for us is only asked for a chunk once in a while, so it still knows we are interested
+				if time.Since(nodeInfo.LastChokedRequest) < ChokedRequestInterval {
 					continue
 				}
+				nodeInfo.LastChokedRequest = time.Now()
+				maxChunks = min(maxChunks, 1)
+			}
 
-				lastRequested, ok := file.PendingChunks.Get(uint16(chunk)) // Check if chunk has already been requested
-				if ok && time.Since(lastRequested) < ChunkRequestTimeoutDuration {
+			chunksToRequest[nodeInfo] = make([]uint16, 0)
+
+			for _, chunkIndex := range missingChunks {
+				if len(chunksToRequest[nodeInfo]) >= maxChunks {
+					break
+				}
+
+				if !nodeInfo.Chunks.Contains(chunkIndex) {
 					continue
 				}
 
-				requestInfo.NumberOfTries++
-				if requestInfo.NumberOfTries >= MaxTriesPerChunk {
-					logger.Warn("Node %s is not responding.", nodeInfo.Address)
-					nodeInfo.Timeouts++
-					if nodeInfo.Timeouts >= MaxNodeTimeouts {
-						logger.Warn("Node %s has timed out 3 times. Removing it from file %s", nodeInfo.Address, file.FileName)
-						file.Nodes.Delete(nodeInfo.Address)
+				if endgame {
+					if nodeInfo.Pending.Contains(chunkIndex) || requests[chunkIndex] >= EndgameRequestsPerChunk {
+						continue
 					}
+					requests[chunkIndex]++
 				} else {
-					chunksToRequest[nodeInfo] = append(chunksToRequest[nodeInfo], uint16(chunk)) // Queue chunk
+					if _, ok := assigned[chunkIndex]; ok || file.IsChunkPending(chunkIndex) {
+						continue
+					}
+					assigned[chunkIndex] = struct{}{}
 				}
+
+				chunksToRequest[nodeInfo] = append(chunksToRequest[nodeInfo], chunkIndex) // Queue chunk
 			}
 		}
 
@@ -240,6 +651,149 @@ func (n *Node) tick() {
 	}
 }
 
+// Holds chunks back until the upload limits allow them to be sent
+func (n *Node) throttleUploads(packet protocol.Packet, addr *net.UDPAddr, size int) {
+	if chunkPacket, ok := packet.(*protocol.ChunkPacket); ok {
+		n.uploadLimits.Wait(addr.String(), chunkPacket.FileName, size)
+	}
+}
+
+// Recomputes the hash of the downloaded file, marking any chunk that does not match on disk as missing
+func (n *Node) verifyDownloadedFile(fileName string, file *ForDownloadFile) {
+	corruptedChunks, err := file.VerifyOnDisk()
+
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	if current, ok := n.forDownload.M[fileName]; !ok || current != file {
+		return // Paused or cancelled while it was verified, which may have deleted the part file
+	}
+
+	file.Verifying = false
+
+	if err != nil {
+		logger.Error("Error verifying file %s: %v. Aborting download", fileName, err)
+		n.stopDownload(fileName, file)
+		return
+	}
+
+	if len(corruptedChunks) == 0 {
+		file.Verified = true
+		return
+	}
+
+	// Corrupted chunks will be downloaded again on the next ticks
+	for _, chunkIndex := range corruptedChunks {
+		file.MarkChunkAsMissing(chunkIndex)
+		file.UnannouncedChunks.Remove(chunkIndex)
+	}
+	n.retractChunks(file)
+	logger.Warn("File %s has %d corrupted chunks on disk, downloading them again", fileName, len(corruptedChunks))
+}
+
+// Moves a verified download to the published files, from where it will be seeded, unless it is not to be seeded.
+// Must be called with the forDownload lock held
+func (n *Node) completeDownload(fileName string, file *ForDownloadFile) {
+	timeToDownload := time.Since(file.DownloadStarted)
+	logger.Info("File %s was successfully downloaded and verified in %s", fileName, timeToDownload.String())
+
+	n.stopDownload(fileName, file)
+
+	// Only now does the file show up at its destination
+	path, err := file.MoveIntoPlace(n.conflictPolicy)
+	if err != nil {
+		logger.Error("Error moving file %s into place, keeping it at %s: %v", fileName, path, err)
+	} else if path != file.FilePath {
+		logger.Info("File %s already existed, saved it as %s instead", file.FilePath, path)
+	}
+
+	newFile := NewFile(file.FileName, path, file.HashAlgorithm, file.FileHash, file.Storage)
+	newFile.ChunkHashes = file.GetChunkHashes()
+	newFile.Size = int64(file.FileSize)
+	if info, err := os.Stat(path); err == nil {
+		newFile.RecordState(info)
+	}
+	if newHash, err := utils.HashFunction(file.HashAlgorithm); err == nil && file.HashMode == protocol.MerkleHashMode {
+		// Every chunk was verified, so their hashes rebuild the tree in order to serve proofs
+		tree := merkle.NewTree(file.GetChunkHashes(), newHash)
+		newFile.MerkleTree = &tree
+	}
+
+	if !n.sharesPublished(fileName) {
+		// Nodes are no longer sent our way, since we will not serve them
+		if n.sharesDownload(fileName) {
+			n.advertiseChunks(fileName, protocol.EncodeBitField(make([]bool, file.NumberOfChunks)))
+		}
+		n.downloadedFile.Put(file.FileName, &newFile)
+		return
+	}
+	n.published.Put(file.FileName, &newFile)
+}
+
+// Stops writing the file and forgets about its download, keeping its part file so it can be resumed.
+// Must be called with the forDownload lock held
+func (n *Node) stopDownload(fileName string, file *ForDownloadFile) {
+	file.FileWriter.Stop()
+	if err := file.SaveState(); err != nil {
+		logger.Warn("Error saving download state of file %s: %v", fileName, err)
+	}
+
+	// We no longer need to know about the nodes who have the file
+	packet := protocol.NewUnsubscribeFilePacket(fileName)
+	n.conn.EnqueuePacket(&packet)
+
+	delete(n.forDownload.M, fileName)
+}
+
+// Gives up on the chunks the node did not send in time, shrinking its window so it is asked for less.
+// Chunks the node keeps failing to send are no longer requested from it, and nodes that keep failing are removed
+func (n *Node) expireRequests(file *ForDownloadFile, nodeInfo *NodeInfo) {
+	timeout := nodeInfo.Window.Timeout()
+	expired := false
+
+	timedOut := make([]uint16, 0)
+	nodeInfo.Pending.ForEach(func(chunkIndex uint16, requested time.Time) {
+		if time.Since(requested) >= timeout {
+			timedOut = append(timedOut, chunkIndex)
+		}
+	})
+
+	// Requests to a choked node are answered with a Choke packet instead of chunks, which is not a failure of the node
+	choked := nodeInfo.Choked.Load()
+
+	for _, chunkIndex := range timedOut {
+		nodeInfo.Pending.Delete(chunkIndex)
+
+		if choked || file.ChunkAlreadyDownloaded(chunkIndex) {
+			continue // Not the node's fault, or arrived from another node
+		}
+		expired = true
+
+		requestInfo, ok := nodeInfo.Chunks.Get(chunkIndex)
+		if !ok {
+			continue
+		}
+
+		requestInfo.NumberOfTries++
+		if requestInfo.NumberOfTries >= MaxTriesPerChunk {
+			file.RemoveNodeChunk(nodeInfo, chunkIndex)
+			nodeInfo.Timeouts++
+		}
+	}
+
+	if !expired {
+		return
+	}
+
+	nodeInfo.Window.OnTimeout()
+	logger.Warn("Node %s is not responding, requesting up to %d chunks of file %s from it", nodeInfo.Address, nodeInfo.Window.Size(), file.FileName)
+
+	if nodeInfo.Timeouts >= MaxNodeTimeouts {
+		logger.Warn("Node %s has timed out %d times. Removing it from file %s", nodeInfo.Address, nodeInfo.Timeouts, file.FileName)
+		file.RemoveNode(nodeInfo.Address)
+	}
+}
+
 func (n *Node) RequestChunks(chunkIndexes []uint16, nodeAddr *net.UDPAddr, file *ForDownloadFile, nodeInfo *NodeInfo) {
 	if len(chunkIndexes) <= 0 {
 		return
@@ -247,6 +801,7 @@ func (n *Node) RequestChunks(chunkIndexes []uint16, nodeAddr *net.UDPAddr, file
 
 	packet := protocol.NewRequestChunksPacket(file.FileName, chunkIndexes)
 	n.srv.EnqueueRequest(&packet, nodeAddr)
+	n.downloadLimits.Take(nodeAddr.String(), file.FileName, len(chunkIndexes)*int(utils.ChunkSize(file.FileSize)))
 
 	// Mark chunks as requested
 	for _, chunkIndex := range chunkIndexes {
@@ -257,6 +812,17 @@ func (n *Node) RequestChunks(chunkIndexes []uint16, nodeAddr *net.UDPAddr, file
 func (n *Node) Stop() {
 	n.srv.Stop()
 	n.tck.Stop()
+	n.checkTck.Stop()
+	n.chokeTck.Stop()
+	n.queueTck.Stop()
+	n.watcher.Stop()
+	if n.discovering {
+		n.mcast.Stop()
+		n.discoveryTck.Stop()
+	}
+	if n.streamServer != nil {
+		_ = n.streamServer.Close()
+	}
 	n.quitChannel <- struct{}{}
 	close(n.quitChannel)
 }
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/storage"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ScheduleDownloadsInterval = time.Second
+	QueueFile                 = ".queue" // Inside the default download directory
+	UnlimitedDownloads        = 0
+)
+
+// QueuedDownload is a file requested to be downloaded, either waiting for a free slot, downloading or paused
+type QueuedDownload struct {
+	FileName string
+	Priority int32 // Downloads with higher priorities are started first
+	Strategy uint8 // How the chunks of the file are selected
+	Paused   uint8 // 1 if the download is paused, either before or after it started
+	Done     uint8 // 1 once its range is downloaded, which is served again after a restart
+	Added    int64 // Unix nanoseconds, downloads of the same priority are started in the order they were added
+	Range    DownloadRange
+}
+
+// Persisted so downloads are resumed after a restart
+type queueState struct {
+	Items []QueuedDownload
+}
+
+// DownloadQueue holds every download until it completes or fails, and decides when each of them starts
+type DownloadQueue struct {
+	items     []*QueuedDownload
+	active    map[string]struct{} // Downloads started, which hold a slot until they complete, fail or are paused
+	maxActive int                 // Zero for unlimited
+	path      string              // Where the queue is persisted
+
+	sync.Mutex
+}
+
+func NewDownloadQueue(maxActive int, path string) *DownloadQueue {
+	return &DownloadQueue{
+		items:     make([]*QueuedDownload, 0),
+		active:    make(map[string]struct{}),
+		maxActive: maxActive,
+		path:      path,
+	}
+}
+
+// Must be called with the lock held
+func (q *DownloadQueue) get(fileName string) (*QueuedDownload, bool) {
+	for _, item := range q.items {
+		if item.FileName == fileName {
+			return item, true
+		}
+	}
+
+	return nil, false
+}
+
+// Must be called with the lock held
+func (q *DownloadQueue) remove(fileName string) {
+	for i, item := range q.items {
+		if item.FileName == fileName {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+
+	delete(q.active, fileName)
+}
+
+// Returns the downloads that are neither started, paused nor done, in the order they should start in.
+// Must be called with the lock held
+func (q *DownloadQueue) waiting() []*QueuedDownload {
+	waiting := make([]*QueuedDownload, 0)
+	for _, item := range q.items {
+		if _, ok := q.active[item.FileName]; !ok && item.Paused == 0 && item.Done == 0 {
+			waiting = append(waiting, item)
+		}
+	}
+
+	sort.SliceStable(waiting, func(i, j int) bool {
+		if waiting[i].Priority != waiting[j].Priority {
+			return waiting[i].Priority > waiting[j].Priority
+		}
+		return waiting[i].Added < waiting[j].Added
+	})
+
+	return waiting
+}
+
+// Returns the downloaded ranges that are not paused. Must be called with the lock held
+func (q *DownloadQueue) done() []*QueuedDownload {
+	done := make([]*QueuedDownload, 0)
+	for _, item := range q.items {
+		if item.Done != 0 && item.Paused == 0 {
+			done = append(done, item)
+		}
+	}
+
+	return done
+}
+
+// Must be called with the lock held
+func (q *DownloadQueue) hasFreeSlot() bool {
+	return q.maxActive == UnlimitedDownloads || len(q.active) < q.maxActive
+}
+
+// Persists the queue, replacing the previous one atomically. Must be called with the lock held
+func (q *DownloadQueue) save() error {
+	state := queueState{Items: make([]QueuedDownload, 0, len(q.items))}
+	for _, item := range q.items {
+		state.Items = append(state.Items, *item)
+	}
+
+	buffer := new(bytes.Buffer)
+	err := protocol.SerializeStruct(buffer, &state)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(q.path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(q.path+".tmp", buffer.Bytes(), storage.Permissions)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(q.path+".tmp", q.path)
+}
+
+// Restores the downloads queued by a previous run, none of which are started yet
+func (q *DownloadQueue) load() error {
+	q.Lock()
+	defer q.Unlock()
+
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var state queueState
+	err = protocol.DeserializeToStruct(bufio.NewReader(file), &state)
+	if err != nil {
+		return err
+	}
+
+	for i := range state.Items {
+		q.items = append(q.items, &state.Items[i])
+	}
+
+	return nil
+}
+
+// Returns true if the file is waiting to be downloaded, downloading or paused
+func (n *Node) isQueued(fileName string) bool {
+	n.queue.Lock()
+	defer n.queue.Unlock()
+
+	_, ok := n.queue.get(fileName)
+	return ok
+}
+
+// Must be called with the lock held
+func (n *Node) saveQueue() {
+	if err := n.queue.save(); err != nil {
+		logger.Warn("Error saving download queue: %v", err)
+	}
+}
+
+// Adds the file to the queue, starting it right away if there is a free slot
+func (n *Node) enqueueDownload(fileName string, priority int32, strategy uint8, downloadRange DownloadRange) error {
+	n.queue.Lock()
+
+	if item, ok := n.queue.get(fileName); ok {
+		if item.Done == 0 {
+			n.queue.Unlock()
+			return fmt.Errorf("file %s is already queued", fileName)
+		}
+
+		// A downloaded range is replaced by the new download, which resumes from it
+		n.queue.remove(fileName)
+	}
+
+	n.queue.items = append(n.queue.items, &QueuedDownload{
+		FileName: fileName,
+		Priority: priority,
+		Strategy: strategy,
+		Added:    time.Now().UnixNano(),
+		Range:    downloadRange,
+	})
+	n.saveQueue()
+	n.queue.Unlock()
+
+	n.scheduleDownloads()
+
+	n.queue.Lock()
+	defer n.queue.Unlock()
+	if _, ok := n.queue.active[fileName]; !ok {
+		logger.Info("Queued file %s, %d downloads are active", fileName, len(n.queue.active))
+	}
+
+	return nil
+}
+
+// Forgets the downloads that completed or failed, frees the slots of those that downloaded their range,
+// and starts those waiting while there are free slots
+func (n *Node) scheduleDownloads() {
+	if !n.connected {
+		return // Downloads are requested from the tracker
+	}
+
+	n.queue.Lock()
+	defer n.queue.Unlock()
+
+	changed := false
+
+	for fileName := range n.queue.active {
+		file, ok := n.forDownload.Get(fileName)
+		if !ok {
+			n.queue.remove(fileName)
+			changed = true
+		} else if file.RangeDownloaded.Load() {
+			// Downloaded ranges are still served, but no longer hold a slot
+			delete(n.queue.active, fileName)
+			if item, ok := n.queue.get(fileName); ok {
+				item.Done = 1
+			}
+			changed = true
+		}
+	}
+
+	// Ranges downloaded by a previous run, or resumed, are served again once their part file is verified
+	for _, item := range n.queue.done() {
+		if n.forDownload.Contains(item.FileName) {
+			continue
+		}
+
+		if n.failedDownloads.Contains(item.FileName) {
+			n.queue.remove(item.FileName)
+		} else {
+			n.startDownload(item)
+		}
+		changed = true
+	}
+
+	for _, item := range n.queue.waiting() {
+		if !n.queue.hasFreeSlot() {
+			break
+		}
+
+		n.queue.active[item.FileName] = struct{}{}
+		n.startDownload(item)
+		changed = true
+	}
+
+	if changed {
+		n.saveQueue()
+	}
+}
+
+// Requests the file from the tracker, and downloads it once it answers
+func (n *Node) startDownload(item *QueuedDownload) {
+	n.failedDownloads.Delete(item.FileName)
+
+	n.forDownload.Lock()
+	if previous, ok := n.forDownload.M[item.FileName]; ok && previous.UpdatedByTracker {
+		// A range downloaded before, which the new download resumes from
+		n.stopDownload(item.FileName, previous)
+	}
+
+	// Data of the file will be updated later, when the tracker responds back
+	file := NewForDownloadFile(item.FileName, item.Strategy)
+	file.Range = item.Range
+	n.forDownload.M[item.FileName] = file
+	n.forDownload.Unlock()
+
+	packet := protocol.NewRequestFilePacket(item.FileName)
+	n.conn.EnqueuePacket(&packet)
+
+	logger.Info("Started download of %s of file %s", item.Range, item.FileName)
+}
+
+// Stops the download if it started, keeping its part file to resume from. Returns where the part file is.
+// Must be called with the queue lock held
+func (n *Node) haltDownload(fileName string) string {
+	delete(n.queue.active, fileName)
+
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	file, ok := n.forDownload.M[fileName]
+	if !ok {
+		return filepath.Join(n.downloadDirectory, fileName) + PartFileSuffix
+	}
+
+	if !file.UpdatedByTracker {
+		// Nothing was written yet, the tracker answer is just ignored
+		delete(n.forDownload.M, fileName)
+		return filepath.Join(n.downloadDirectory, fileName) + PartFileSuffix
+	}
+
+	n.stopDownload(fileName, file)
+	return file.PartPath
+}
+
+// pause <file name>
+func (n *Node) pauseDownload(args []string) error {
+	n.queue.Lock()
+	defer n.queue.Unlock()
+
+	item, ok := n.queue.get(args[0])
+	if !ok {
+		return fmt.Errorf("file %s is not queued", args[0])
+	}
+
+	item.Paused = 1
+	n.haltDownload(item.FileName)
+	n.saveQueue()
+
+	logger.Info("Paused download of file %s", item.FileName)
+
+	return nil
+}
+
+// resume <file name>
+func (n *Node) resumeDownload(args []string) error {
+	n.queue.Lock()
+
+	item, ok := n.queue.get(args[0])
+	if !ok {
+		n.queue.Unlock()
+		return fmt.Errorf("file %s is not queued", args[0])
+	}
+
+	item.Paused = 0
+	n.saveQueue()
+	n.queue.Unlock()
+
+	n.scheduleDownloads()
+
+	return nil
+}
+
+// cancel <file name>
+func (n *Node) cancelDownload(args []string) error {
+	n.queue.Lock()
+
+	if _, ok := n.queue.get(args[0]); !ok {
+		n.queue.Unlock()
+		return fmt.Errorf("file %s is not queued", args[0])
+	}
+
+	partPath := n.haltDownload(args[0])
+	n.queue.remove(args[0])
+	n.saveQueue()
+	n.queue.Unlock()
+
+	// Nothing is kept to resume from
+	_ = n.storage.Remove(partPath)
+	_ = os.Remove(partPath + StateFileSuffix)
+
+	logger.Info("Cancelled download of file %s", args[0])
+
+	// Its slot goes to the next download
+	n.scheduleDownloads()
+
+	return nil
+}
+
+// set-download-priority <file name> <priority>
+func (n *Node) setDownloadPriority(args []string) error {
+	priority, err := parsePriority(args[1])
+	if err != nil {
+		return err
+	}
+
+	n.queue.Lock()
+	defer n.queue.Unlock()
+
+	item, ok := n.queue.get(args[0])
+	if !ok {
+		return fmt.Errorf("file %s is not queued", args[0])
+	}
+
+	item.Priority = priority
+	n.saveQueue()
+
+	return nil
+}
+
+// set-max-downloads <number of downloads>
+func (n *Node) setMaxDownloads(args []string) error {
+	maxActive, err := parseMaxDownloads(args[0])
+	if err != nil {
+		return err
+	}
+
+	n.queue.Lock()
+	n.queue.maxActive = maxActive
+	n.queue.Unlock()
+
+	// Downloads already started keep their slots, only new ones wait for more to free up
+	n.scheduleDownloads()
+
+	return nil
+}
+
+// Changes how the chunks of a queued download are selected, once it starts and after a restart
+func (n *Node) setQueuedStrategy(fileName string, strategy uint8) {
+	n.queue.Lock()
+	defer n.queue.Unlock()
+
+	if item, ok := n.queue.get(fileName); ok {
+		item.Strategy = strategy
+		n.saveQueue()
+	}
+}
+
+// queue
+func (n *Node) showQueue(_ []string) error {
+	n.queue.Lock()
+	defer n.queue.Unlock()
+
+	if n.queue.maxActive == UnlimitedDownloads {
+		logger.Info("Active downloads: %d", len(n.queue.active))
+	} else {
+		logger.Info("Active downloads: %d/%d", len(n.queue.active), n.queue.maxActive)
+	}
+
+	for _, item := range n.queue.items {
+		state := "waiting"
+		if _, ok := n.queue.active[item.FileName]; ok {
+			state = "downloading"
+		} else if item.Paused != 0 {
+			state = "paused"
+		} else if item.Done != 0 {
+			state = "done"
+		}
+
+		logger.Info("%s (%s) with priority %d: %s", item.FileName, item.Range, item.Priority, state)
+	}
+
+	return nil
+}
+
+func parsePriority(value string) (int32, error) {
+	priority, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(priority), nil
+}
+
+func parseMaxDownloads(value string) (int, error) {
+	maxActive, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(maxActive), nil
+}
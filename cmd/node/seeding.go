@@ -0,0 +1,185 @@
+package main
+
+import (
+	"PessiTorrent/internal/filereader"
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// What to do when a published file is modified or deleted on disk
+const (
+	WithdrawOnChange  = 0 // Stop seeding the file and remove it from the network
+	RepublishOnChange = 1 // Remove the file from the network and publish its new content
+)
+
+var changePolicyNames = map[uint8]string{
+	WithdrawOnChange:  "withdraw",
+	RepublishOnChange: "republish",
+}
+
+func ChangePolicyName(policy uint8) string {
+	return changePolicyNames[policy]
+}
+
+func ParseChangePolicy(name string) (uint8, error) {
+	for policy, policyName := range changePolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return policy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown change policy: %s", name)
+}
+
+// Remembers the size and modification time of the file on disk, as it was when it was hashed
+func (f *File) RecordState(info os.FileInfo) {
+	f.Size = info.Size()
+	f.ModTime = info.ModTime()
+}
+
+// Returns true if the file on disk was modified or deleted since its state was recorded.
+// Files without a recorded state are never considered changed
+func (f *File) HasChanged() bool {
+	if f.ModTime.IsZero() {
+		return false
+	}
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return true
+	}
+
+	return info.Size() != f.Size || !info.ModTime().Equal(f.ModTime)
+}
+
+// Checks the content read from disk against the hash the chunk was published with
+func (f *File) VerifyChunk(chunkIndex uint16, chunkContent []byte) bool {
+	if f.ChunkHashes == nil {
+		return true // Chunks were already verified when they were downloaded
+	}
+
+	if int(chunkIndex) >= len(f.ChunkHashes) {
+		return false
+	}
+
+	return bytes.Equal(utils.HashChunk(f.HashAlgorithm, chunkContent), f.ChunkHashes[chunkIndex])
+}
+
+var errCorruptedChunk = errors.New("chunk does not match its hash")
+
+// Identifies a chunk in the chunk cache
+type chunkKey struct {
+	path  string
+	index uint16
+}
+
+// Reads a chunk of the file, verifying it against its hash, unless it was recently served from the cache.
+// The content must not be used after calling release
+func (n *Node) readChunk(file *File, chunkIndex uint16, chunkSize uint64, cached bool) ([]byte, func(), error) {
+	key := chunkKey{file.Path, chunkIndex}
+	if cached {
+		if chunkContent, ok := n.chunkCache.Get(key); ok {
+			return chunkContent, func() {}, nil
+		}
+	}
+
+	buffer := filereader.GetBuffer(int(chunkSize))
+	read, err := file.Storage.ReadAt(file.Path, buffer, int64(uint64(chunkIndex)*chunkSize))
+	if err != nil {
+		filereader.PutBuffer(buffer)
+		return nil, nil, err
+	}
+
+	chunkContent := buffer[:read]
+	if !file.VerifyChunk(chunkIndex, chunkContent) {
+		filereader.PutBuffer(buffer)
+		return nil, nil, errCorruptedChunk
+	}
+
+	if cached {
+		n.chunkCache.Put(key, bytes.Clone(chunkContent))
+	}
+
+	return chunkContent, func() { filereader.PutBuffer(buffer) }, nil
+}
+
+// Drops the open handles and cached chunks of a file whose content is no longer trusted
+func (n *Node) forgetFileContent(file *File) {
+	path := file.Path
+	file.Storage.Close(path)
+	n.chunkCache.DeleteWhere(func(key chunkKey) bool {
+		return key.path == path
+	})
+}
+
+// Stats every published file, handling those that were modified or deleted since they were published
+func (n *Node) checkPublishedFiles() {
+	for _, file := range n.published.Values() {
+		if file.HasChanged() {
+			n.handleChangedFile(file, n.changePolicy)
+		}
+	}
+}
+
+// Stops seeding a file whose content no longer matches its hash, and withdraws or republishes it
+func (n *Node) handleChangedFile(file *File, policy uint8) {
+	if n.deferPendingChange(file, policy) {
+		return
+	}
+
+	// Only the first to notice the change handles it
+	n.published.Lock()
+	current, ok := n.published.M[file.FileName]
+	if !ok || current != file {
+		n.published.Unlock()
+		return
+	}
+	delete(n.published.M, file.FileName)
+	n.published.Unlock()
+
+	logger.Warn("File %s was modified or deleted since it was published, no longer seeding it", file.Path)
+	n.forgetFileContent(file)
+
+	packet := protocol.NewRemoveFilePacket(file.FileName)
+	n.conn.EnqueuePacket(&packet)
+
+	if policy != RepublishOnChange {
+		return
+	}
+
+	if _, err := os.Stat(file.Path); err != nil {
+		return // Nothing left to republish
+	}
+
+	// The tracker handles the removal before the new publish, since both go through the same connection
+	logger.Info("Republishing file %s with its new content", file.Path)
+	go func() {
+		if err := n.publishFile(file.Path); err != nil {
+			logger.Error("Error republishing file %s: %v", file.Path, err)
+		}
+	}()
+}
+
+// Postpones handling the change of a file until the tracker answers its publish, since the file can neither
+// be removed from the network nor published again before then. Returns false if the file is not pending
+func (n *Node) deferPendingChange(file *File, policy uint8) bool {
+	n.pending.Lock()
+	defer n.pending.Unlock()
+
+	current, ok := n.pending.M[file.FileName]
+	if !ok || current != file {
+		return false
+	}
+
+	file.ChangedWhilePending = true
+	file.PendingChangePolicy = policy
+	logger.Info("File %s changed before it was published, handling it once the tracker answers", file.Path)
+
+	return true
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/utils"
+	"fmt"
+	"strings"
+)
+
+// Who a file is served to. Published files are seeded unless told otherwise,
+// while downloads follow the default policy of the node
+const (
+	SeedSharing      = 0 // Served while it is downloaded and seeded once complete
+	LeechOnlySharing = 1 // Served while it is downloaded, but not seeded once complete
+	PrivateSharing   = 2 // Never served nor advertised to other nodes
+)
+
+var sharingPolicyNames = map[uint8]string{
+	SeedSharing:      "seed",
+	LeechOnlySharing: "leech-only",
+	PrivateSharing:   "private",
+}
+
+func SharingPolicyName(policy uint8) string {
+	return sharingPolicyNames[policy]
+}
+
+func ParseSharingPolicy(name string) (uint8, error) {
+	for policy, policyName := range sharingPolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return policy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown sharing policy: %s", name)
+}
+
+// Returns the policy set for the file, or the given one if none was
+func (n *Node) sharingPolicy(fileName string, defaultPolicy uint8) uint8 {
+	if policy, ok := n.sharing.Get(fileName); ok {
+		return policy
+	}
+
+	return defaultPolicy
+}
+
+// Returns true if the chunks of the file being downloaded are served to other nodes
+func (n *Node) sharesDownload(fileName string) bool {
+	return n.sharingPolicy(fileName, n.defaultSharing) != PrivateSharing
+}
+
+// Returns true if the complete file is seeded to other nodes
+func (n *Node) sharesPublished(fileName string) bool {
+	return n.sharingPolicy(fileName, SeedSharing) == SeedSharing
+}
+
+// Tells the tracker which chunks of the file we offer, so it stops or starts sending nodes our way
+func (n *Node) advertiseChunks(fileName string, bitfield protocol.Bitfield) {
+	packet := protocol.NewUpdateChunksPacket(fileName, bitfield)
+	n.conn.EnqueuePacket(&packet)
+}
+
+// Applies a new sharing policy to a file, whether it is published, being downloaded or already downloaded
+func (n *Node) applySharingPolicy(fileName string, policy uint8) {
+	n.sharing.Put(fileName, policy)
+
+	if file, ok := n.published.Get(fileName); ok {
+		numberOfChunks := int(utils.NumberOfChunks(uint64(file.Size)))
+		if policy == SeedSharing {
+			n.advertiseChunks(fileName, protocol.NewCheckedBitfield(numberOfChunks))
+		} else {
+			n.published.Delete(fileName)
+			n.forgetFileContent(file)
+			n.downloadedFile.Put(fileName, file)
+			n.advertiseChunks(fileName, protocol.EncodeBitField(make([]bool, numberOfChunks)))
+			logger.Info("Stopped seeding file %s", fileName)
+		}
+		return
+	}
+
+	if file, ok := n.downloadedFile.Get(fileName); ok {
+		if policy == SeedSharing {
+			n.downloadedFile.Delete(fileName)
+			n.published.Put(fileName, file)
+			n.advertiseChunks(fileName, protocol.NewCheckedBitfield(int(utils.NumberOfChunks(uint64(file.Size)))))
+			logger.Info("Seeding file %s", fileName)
+		}
+		return
+	}
+
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	file, ok := n.forDownload.M[fileName]
+	if !ok || !file.UpdatedByTracker {
+		return // Applied once the download starts
+	}
+
+	// The tracker gets the whole bitfield again on the next update, unless the file is now private
+	file.ReportedToTracker = false
+	if policy == PrivateSharing {
+		n.advertiseChunks(fileName, protocol.EncodeBitField(make([]bool, file.NumberOfChunks)))
+	}
+}
+
+// Returns the requested chunks that can not be served, because they are out of bounds or not downloaded yet
+func unavailableChunks(chunks []uint16, numberOfChunks uint16, downloaded func(chunkIndex uint16) bool) []uint16 {
+	unavailable := make([]uint16, 0)
+	for _, chunk := range chunks {
+		if chunk >= numberOfChunks || (downloaded != nil && !downloaded(chunk)) {
+			unavailable = append(unavailable, chunk)
+		}
+	}
+
+	return unavailable
+}
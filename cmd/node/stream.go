@@ -0,0 +1,326 @@
+package main
+
+import (
+	"PessiTorrent/internal/filereader"
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/selection"
+	"PessiTorrent/internal/storage"
+	"PessiTorrent/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StreamPath         = "/files/"
+	StreamPollInterval = 50 * time.Millisecond // How often a stream checks whether the chunk it waits for arrived
+	StreamReadRetries  = 2                     // The part file may be moved into place while it is read
+	StreamPriority     = math.MaxInt32         // Streamed downloads start before any other
+)
+
+var (
+	errNotStreamable = errors.New("file is neither queued nor complete")
+	errNotWanted     = errors.New("range is outside of the part of the file being downloaded")
+)
+
+// Serves the files being downloaded, and those complete, over HTTP with Range support,
+// so a video can be played before it is fully downloaded.
+// Listens before returning, so the server is only kept if it could listen, and serves in the background
+func (n *Node) startStreaming() {
+	listener, err := net.Listen("tcp", n.streamAddr)
+	if err != nil {
+		logger.Error("Failed to stream files on %s: %s", n.streamAddr, err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(StreamPath, n.handleStream)
+
+	server := &http.Server{Handler: mux}
+	n.streamServer = server
+
+	logger.Info("Streaming files on http://%s%s", n.streamAddr, StreamPath)
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Stopped streaming files on %s: %s", n.streamAddr, err)
+		}
+	}()
+}
+
+// Handler for GET /files/<file name>
+func (n *Node) handleStream(w http.ResponseWriter, r *http.Request) {
+	fileName := strings.TrimPrefix(r.URL.Path, StreamPath)
+	if fileName == "" || strings.Contains(fileName, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	size, err := n.waitForFileSize(r.Context(), fileName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Downloads of a range never get the rest of the file, so streams must stay inside of it
+	if !n.rangeIsWanted(fileName, r.Header.Get("Range"), int64(size)) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		http.Error(w, errNotWanted.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	logger.Info("Streaming file %s to %s", fileName, r.RemoteAddr)
+
+	reader := &streamReader{
+		node:     n,
+		ctx:      r.Context(),
+		fileName: fileName,
+		size:     int64(size),
+		cached:   -1,
+	}
+	defer reader.Close()
+
+	// Handles Range requests, reading only the chunks covering them
+	http.ServeContent(w, r, fileName, time.Time{}, reader)
+}
+
+// Returns the size of the file, waiting for the tracker to tell it if the download just started
+func (n *Node) waitForFileSize(ctx context.Context, fileName string) (uint64, error) {
+	for {
+		if file, ok := n.forDownload.Get(fileName); ok {
+			if file.UpdatedByTracker {
+				return file.FileSize, nil
+			}
+		} else if file, ok := n.completeFile(fileName); ok {
+			return uint64(file.Size), nil
+		} else if !n.isQueued(fileName) {
+			return 0, errNotStreamable
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(StreamPollInterval):
+		}
+	}
+}
+
+// Returns true unless the download of the file only wants a range of it, and the Range header,
+// or the whole file if there is none, asks for chunks outside of it
+func (n *Node) rangeIsWanted(fileName string, header string, size int64) bool {
+	file, ok := n.forDownload.Get(fileName)
+	if !ok || file.Wanted == nil || size == 0 {
+		return true
+	}
+
+	ranges := [][2]int64{{0, size - 1}}
+	if header != "" {
+		var ok bool
+		if ranges, ok = parseByteRanges(header, size); !ok {
+			return true // Answered by http.ServeContent
+		}
+	}
+
+	chunkSize := int64(utils.ChunkSize(uint64(size)))
+	for _, byteRange := range ranges {
+		for chunkIndex := byteRange[0] / chunkSize; chunkIndex <= byteRange[1]/chunkSize; chunkIndex++ {
+			if !file.IsChunkWanted(uint16(chunkIndex)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Parses a Range header such as "bytes=0-1023,4096-" into the first and last byte of each range,
+// clipped to the end of the file
+func parseByteRanges(header string, size int64) ([][2]int64, bool) {
+	specs, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return nil, false
+	}
+
+	ranges := make([][2]int64, 0)
+	for _, spec := range strings.Split(specs, ",") {
+		first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return nil, false
+		}
+
+		if first == "" {
+			// Suffix range, the last bytes of the file
+			length, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || length <= 0 {
+				return nil, false
+			}
+			ranges = append(ranges, [2]int64{max(size-length, 0), size - 1})
+			continue
+		}
+
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start >= size {
+			return nil, false
+		}
+
+		end := size - 1
+		if last != "" {
+			if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+				return nil, false
+			}
+			end = min(end, size-1)
+		}
+		ranges = append(ranges, [2]int64{start, end})
+	}
+
+	return ranges, true
+}
+
+// Returns the file if it was downloaded or published
+func (n *Node) completeFile(fileName string) (*File, bool) {
+	if file, ok := n.published.Get(fileName); ok {
+		return file, true
+	}
+
+	return n.downloadedFile.Get(fileName)
+}
+
+// Waits until the chunk of the file can be read, returning where to read it from.
+// Downloads streamed with the streaming selection request the chunks after it first
+func (n *Node) waitForChunk(ctx context.Context, fileName string, chunkIndex uint16) (storage.Storage, string, error) {
+	for {
+		if file, ok := n.forDownload.Get(fileName); ok {
+			if file.UpdatedByTracker && !file.IsChunkWanted(chunkIndex) {
+				return nil, "", errNotWanted // Would never be downloaded
+			}
+
+			n.moveCursor(fileName, chunkIndex)
+			if file.UpdatedByTracker && file.ChunkAlreadyDownloaded(chunkIndex) {
+				return file.Storage, file.PartPath, nil
+			}
+		} else if file, ok := n.completeFile(fileName); ok {
+			return file.Storage, file.Path, nil
+		} else if !n.isQueued(fileName) {
+			return nil, "", errNotStreamable
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-time.After(StreamPollInterval):
+		}
+	}
+}
+
+// Moves the playback cursor of the download, if it is streamed
+func (n *Node) moveCursor(fileName string, chunkIndex uint16) {
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	file, ok := n.forDownload.M[fileName]
+	if !ok {
+		return
+	}
+
+	if streaming, ok := file.Selector.(*selection.Streaming); ok {
+		streaming.SetCursor(chunkIndex)
+	}
+}
+
+// streamReader reads a file a chunk at a time, blocking on chunks that were not downloaded yet
+type streamReader struct {
+	node     *Node
+	ctx      context.Context
+	fileName string
+	size     int64
+	offset   int64
+
+	chunk  []byte // Content of the last chunk read
+	cached int    // Index of the last chunk read, -1 if none
+}
+
+func (r *streamReader) Read(buffer []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	chunkSize := int64(utils.ChunkSize(uint64(r.size)))
+	chunkIndex := r.offset / chunkSize
+
+	if int(chunkIndex) != r.cached {
+		if err := r.readChunk(uint16(chunkIndex), chunkSize); err != nil {
+			return 0, err
+		}
+	}
+
+	start := r.offset - chunkIndex*chunkSize
+	if start >= int64(len(r.chunk)) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	read := copy(buffer, r.chunk[start:])
+	r.offset += int64(read)
+	return read, nil
+}
+
+func (r *streamReader) readChunk(chunkIndex uint16, chunkSize int64) error {
+	if r.chunk == nil {
+		r.chunk = filereader.GetBuffer(int(chunkSize))
+	}
+	r.chunk = r.chunk[:chunkSize]
+
+	var err error
+	for try := 0; try < StreamReadRetries; try++ {
+		var store storage.Storage
+		var path string
+		store, path, err = r.node.waitForChunk(r.ctx, r.fileName, chunkIndex)
+		if err != nil {
+			return err
+		}
+
+		var read int
+		read, err = store.ReadAt(path, r.chunk, int64(chunkIndex)*chunkSize)
+		if err == nil {
+			r.chunk = r.chunk[:read]
+			r.cached = int(chunkIndex)
+			return nil
+		}
+	}
+
+	r.cached = -1
+	return fmt.Errorf("error reading chunk %d of file %s: %w", chunkIndex, r.fileName, err)
+}
+
+func (r *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *streamReader) Close() {
+	if r.chunk != nil {
+		filereader.PutBuffer(r.chunk)
+		r.chunk = nil
+	}
+}
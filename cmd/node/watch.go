@@ -0,0 +1,94 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/watcher"
+	"path/filepath"
+	"strings"
+)
+
+// Keeps the files of the watched directories published: new files are published,
+// changed files are republished and deleted files are removed from the network
+func (n *Node) handleWatchEvent(event watcher.Event) {
+	// Downloads in progress are not complete files yet
+	if strings.HasSuffix(event.Path, PartFileSuffix) || strings.HasSuffix(event.Path, StateFileSuffix) {
+		return
+	}
+
+	file := n.findFileByPath(event.Path)
+
+	switch event.Type {
+	case watcher.Created:
+		if file != nil {
+			return // Already being seeded, e.g. a file downloaded into a watched directory
+		}
+
+		if !n.connected {
+			n.watcher.Forget(event.Path) // Published by a later scan, once connected
+			return
+		}
+
+		logger.Info("New file %s in watched directory, publishing it", event.Path)
+		if err := n.publishFile(event.Path); err != nil {
+			logger.Error("Error publishing file %s: %v", event.Path, err)
+		}
+	case watcher.Modified:
+		if file == nil {
+			// Either never published, or already withdrawn after the change was noticed while seeding
+			if !n.connected {
+				n.watcher.Forget(event.Path) // Published by a later scan, once connected
+				return
+			}
+
+			logger.Info("File %s in watched directory changed, publishing it", event.Path)
+			if err := n.publishFile(event.Path); err != nil {
+				logger.Error("Error publishing file %s: %v", event.Path, err)
+			}
+			return
+		}
+
+		n.handleChangedFile(file, RepublishOnChange)
+	case watcher.Removed:
+		if file == nil {
+			return
+		}
+
+		n.handleChangedFile(file, WithdrawOnChange)
+	default:
+		logger.Warn("Unknown watch event type: %v", event.Type)
+	}
+}
+
+// Returns the published, pending or downloaded file at the given path, or nil if there is none
+func (n *Node) findFileByPath(path string) *File {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+
+	isAtPath := func(file *File) bool {
+		filePath, err := filepath.Abs(file.Path)
+		return err == nil && filePath == path
+	}
+
+	for _, file := range n.published.Values() {
+		if isAtPath(file) {
+			return file
+		}
+	}
+
+	for _, file := range n.pending.Values() {
+		if isAtPath(file) {
+			return file
+		}
+	}
+
+	// Downloads that are not seeded stay that way, even inside a watched directory
+	for _, file := range n.downloadedFile.Values() {
+		if isAtPath(file) {
+			return file
+		}
+	}
+
+	return nil
+}
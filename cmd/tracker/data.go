@@ -4,30 +4,44 @@ import (
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/structures"
 	"PessiTorrent/internal/transport"
+	"PessiTorrent/internal/utils"
 )
 
 type TrackedFile struct {
-	FileName    string
-	FileSize    uint64
-	FileHash    [20]byte
-	ChunkHashes [][20]byte
+	FileName      string
+	FileSize      uint64
+	HashAlgorithm uint8
+	FileHash      []byte
+	HashMode      uint8
+	MerkleRoot    []byte
+	ChunkHashes   [][]byte // Empty if HashMode is MerkleHashMode
 }
 
-func NewTrackedFile(fileName string, fileSize uint64, fileHash [20]byte, chunkHashes [][20]byte) TrackedFile {
+func NewTrackedFile(fileName string, fileSize uint64, hashAlgorithm uint8, fileHash []byte, hashMode uint8, merkleRoot []byte, chunkHashes [][]byte) TrackedFile {
 	return TrackedFile{
-		FileName:    fileName,
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		HashAlgorithm: hashAlgorithm,
+		FileHash:      fileHash,
+		HashMode:      hashMode,
+		MerkleRoot:    merkleRoot,
+		ChunkHashes:   chunkHashes,
 	}
 }
 
+func (tf *TrackedFile) NumberOfChunks() int {
+	return int(utils.NumberOfChunks(tf.FileSize))
+}
+
 type NodeInfo struct {
 	name    string
 	conn    transport.TCPConnection
 	udpPort uint16
 
 	files structures.SynchronizedMap[string, protocol.Bitfield]
+
+	// Files the node wants to be notified about
+	subscriptions structures.SynchronizedMap[string, struct{}]
 }
 
 func NewNodeInfo(conn transport.TCPConnection, udpPort uint16, name string) NodeInfo {
@@ -36,5 +50,7 @@ func NewNodeInfo(conn transport.TCPConnection, udpPort uint16, name string) Node
 		conn:    conn,
 		udpPort: udpPort,
 		files:   structures.NewSynchronizedMap[string, protocol.Bitfield](),
+
+		subscriptions: structures.NewSynchronizedMap[string, struct{}](),
 	}
 }
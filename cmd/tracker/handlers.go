@@ -4,6 +4,8 @@ import (
 	"PessiTorrent/internal/logger"
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/transport"
+	"PessiTorrent/internal/utils"
+	"fmt"
 )
 
 func (t *Tracker) HandlePackets(packet protocol.Packet, conn *transport.TCPConnection) {
@@ -20,6 +22,12 @@ func (t *Tracker) HandlePackets(packet protocol.Packet, conn *transport.TCPConne
 		t.handleRemoveFilePacket(packet, conn)
 	case *protocol.UpdateChunksPacket:
 		t.handlePublishChunkPacket(packet, conn)
+	case *protocol.UpdateChunksDeltaPacket:
+		t.handleUpdateChunksDeltaPacket(packet, conn)
+	case *protocol.SubscribeFilePacket:
+		t.handleSubscribeFilePacket(packet, conn)
+	case *protocol.UnsubscribeFilePacket:
+		t.handleUnsubscribeFilePacket(packet, conn)
 	default:
 		logger.Error("Unknown packet type received from %s", conn.RemoteAddr())
 	}
@@ -46,14 +54,24 @@ func (t *Tracker) handlePublishFilePacket(packet *protocol.PublishFilePacket, co
 		return
 	}
 
+	if err := validatePublish(packet); err != nil {
+		logger.Info("File %s published from %s is invalid: %v", packet.FileName, conn.RemoteAddr(), err)
+
+		ipPacket := protocol.NewInvalidPublishPacket(packet.FileName, err.Error())
+		conn.EnqueuePacket(&ipPacket)
+		return
+	}
+
 	// Add file to the tracker
-	file := NewTrackedFile(packet.FileName, packet.FileSize, packet.FileHash, packet.ChunkHashes)
+	file := NewTrackedFile(packet.FileName, packet.FileSize, packet.HashAlgorithm, packet.FileHash, packet.HashMode, packet.MerkleRoot, packet.ChunkHashes)
 	t.files.Put(packet.FileName, &file)
 
 	// Add file to the node's list of files
 	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
 	if ok {
-		nodeInfo.files.Put(packet.FileName, protocol.NewCheckedBitfield(len(packet.ChunkHashes)))
+		bitfield := protocol.NewCheckedBitfield(file.NumberOfChunks())
+		nodeInfo.files.Put(packet.FileName, bitfield)
+		t.notifySubscribers(packet.FileName, protocol.NodeJoined, nodeInfo, bitfield)
 	}
 
 	// Send response back to the node
@@ -61,6 +79,40 @@ func (t *Tracker) handlePublishFilePacket(packet *protocol.PublishFilePacket, co
 	conn.EnqueuePacket(&pfsPacket)
 }
 
+// Returns why the hashes of the published file can not be used, or nil if they can.
+// Every digest must be of the size of the algorithm the file was published with
+func validatePublish(packet *protocol.PublishFilePacket) error {
+	hashSize := utils.HashSize(packet.HashAlgorithm)
+	if hashSize == 0 {
+		return fmt.Errorf("unsupported hash algorithm %d", packet.HashAlgorithm)
+	}
+
+	if len(packet.FileHash) != hashSize {
+		return fmt.Errorf("file hash has %d bytes instead of %d", len(packet.FileHash), hashSize)
+	}
+
+	switch packet.HashMode {
+	case protocol.FlatHashMode:
+		if packet.FileSize > 0 && uint64(len(packet.ChunkHashes)) != utils.NumberOfChunks(packet.FileSize) {
+			return fmt.Errorf("%d chunk hashes instead of %d", len(packet.ChunkHashes), utils.NumberOfChunks(packet.FileSize))
+		}
+
+		for i, chunkHash := range packet.ChunkHashes {
+			if len(chunkHash) != hashSize {
+				return fmt.Errorf("hash of chunk %d has %d bytes instead of %d", i, len(chunkHash), hashSize)
+			}
+		}
+	case protocol.MerkleHashMode:
+		if len(packet.MerkleRoot) != hashSize {
+			return fmt.Errorf("Merkle root has %d bytes instead of %d", len(packet.MerkleRoot), hashSize)
+		}
+	default:
+		return fmt.Errorf("unsupported hash mode %d", packet.HashMode)
+	}
+
+	return nil
+}
+
 func (t *Tracker) handleRequestFilePacket(packet *protocol.RequestFilePacket, conn *transport.TCPConnection) {
 	logger.Info("Request file packet received from %s", conn.RemoteAddr())
 
@@ -78,7 +130,7 @@ func (t *Tracker) handleRequestFilePacket(packet *protocol.RequestFilePacket, co
 		})
 
 		// Send file name, hash and chunks hashes
-		anPacket := protocol.NewAnswerFileWithNodesPacket(file.FileName, file.FileSize, file.FileHash, file.ChunkHashes, names, ports, bitfields)
+		anPacket := protocol.NewAnswerFileWithNodesPacket(file.FileName, file.FileSize, file.HashAlgorithm, file.FileHash, file.HashMode, file.MerkleRoot, file.ChunkHashes, names, ports, bitfields)
 		conn.EnqueuePacket(&anPacket)
 	} else {
 		logger.Info("File %s requested from %s does not exist", packet.FileName, conn.RemoteAddr())
@@ -92,22 +144,26 @@ func (t *Tracker) handleUpdateFilePacket(packet *protocol.UpdateFilePacket, conn
 	logger.Info("Update file packet received from %s", conn.RemoteAddr())
 
 	if file, ok := t.files.Get(packet.FileName); ok {
-		var ipAddrs []string
-		var ports []uint16
-		var bitfields []protocol.Bitfield
+		t.sendFileNodes(file, conn)
+	}
+}
 
-		t.nodes.ForEach(func(_ string, node *NodeInfo) {
-			if bitfield, exists := node.files.Get(packet.FileName); exists {
-				ipAddrs = append(ipAddrs, node.name)
-				ports = append(ports, node.udpPort)
-				bitfields = append(bitfields, bitfield)
-			}
-		})
+// Sends the nodes who have the given file, and their bitfields
+func (t *Tracker) sendFileNodes(file *TrackedFile, conn *transport.TCPConnection) {
+	var ipAddrs []string
+	var ports []uint16
+	var bitfields []protocol.Bitfield
 
-		// Send file name, hash and chunks hashes
-		anPacket := protocol.NewAnswerNodesPacket(file.FileName, ipAddrs, ports, bitfields)
-		conn.EnqueuePacket(&anPacket)
-	}
+	t.nodes.ForEach(func(_ string, node *NodeInfo) {
+		if bitfield, exists := node.files.Get(file.FileName); exists {
+			ipAddrs = append(ipAddrs, node.name)
+			ports = append(ports, node.udpPort)
+			bitfields = append(bitfields, bitfield)
+		}
+	})
+
+	anPacket := protocol.NewAnswerNodesPacket(file.FileName, ipAddrs, ports, bitfields)
+	conn.EnqueuePacket(&anPacket)
 }
 
 func (t *Tracker) handleRemoveFilePacket(packet *protocol.RemoveFilePacket, conn *transport.TCPConnection) {
@@ -120,6 +176,7 @@ func (t *Tracker) handleRemoveFilePacket(packet *protocol.RemoveFilePacket, conn
 		nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
 		if ok {
 			nodeInfo.files.Delete(packet.FileName)
+			t.notifySubscribers(packet.FileName, protocol.NodeLeft, nodeInfo, nil)
 		}
 
 		rfsPacket := protocol.NewRemoveFileSuccessPacket(packet.FileName)
@@ -138,6 +195,62 @@ func (t *Tracker) handlePublishChunkPacket(packet *protocol.UpdateChunksPacket,
 	// Update node's bitfield
 	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
 	if ok {
+		updateType := uint8(protocol.NodeUpdated)
+		if !nodeInfo.files.Contains(packet.FileName) {
+			updateType = protocol.NodeJoined
+		}
+
 		nodeInfo.files.Put(packet.FileName, packet.Bitfield)
+		t.notifySubscribers(packet.FileName, updateType, nodeInfo, packet.Bitfield)
+	}
+}
+
+func (t *Tracker) handleUpdateChunksDeltaPacket(packet *protocol.UpdateChunksDeltaPacket, conn *transport.TCPConnection) {
+	logger.Info("Update chunks delta packet received from %s", conn.RemoteAddr())
+
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if !ok {
+		return
+	}
+
+	file, ok := t.files.Get(packet.FileName)
+	if !ok {
+		return
+	}
+
+	// Apply only the newly acquired chunks to the node's bitfield
+	updateType := uint8(protocol.NodeUpdated)
+	bitfield, exists := nodeInfo.files.Get(packet.FileName)
+	if !exists {
+		updateType = protocol.NodeJoined
+		bitfield = protocol.EncodeBitField(make([]bool, file.NumberOfChunks()))
+	}
+
+	bitfield = protocol.ApplyChunkRanges(bitfield, packet.Ranges)
+	nodeInfo.files.Put(packet.FileName, bitfield)
+	t.notifySubscribers(packet.FileName, updateType, nodeInfo, bitfield)
+}
+
+func (t *Tracker) handleSubscribeFilePacket(packet *protocol.SubscribeFilePacket, conn *transport.TCPConnection) {
+	logger.Info("Subscribe file packet received from %s", conn.RemoteAddr())
+
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if !ok || !t.files.Contains(packet.FileName) {
+		return
+	}
+
+	nodeInfo.subscriptions.Put(packet.FileName, struct{}{})
+
+	// Send the current state of the file, later changes are pushed as they happen
+	file, _ := t.files.Get(packet.FileName)
+	t.sendFileNodes(file, conn)
+}
+
+func (t *Tracker) handleUnsubscribeFilePacket(packet *protocol.UnsubscribeFilePacket, conn *transport.TCPConnection) {
+	logger.Info("Unsubscribe file packet received from %s", conn.RemoteAddr())
+
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if ok {
+		nodeInfo.subscriptions.Delete(packet.FileName)
 	}
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/structures"
 	"PessiTorrent/internal/transport"
 	"net"
@@ -67,10 +68,36 @@ func (t *Tracker) acceptConnections() {
 
 		conn := transport.NewTCPConnection(cn, t.HandlePackets, func() {
 			logger.Info("Node %s disconnected", cn.RemoteAddr())
-			t.nodes.Delete(cn.RemoteAddr().String())
+			t.removeNode(cn.RemoteAddr().String())
 		})
 		logger.Info("Node %s connected", conn.RemoteAddr())
 
 		go conn.Start()
 	}
 }
+
+func (t *Tracker) removeNode(addr string) {
+	nodeInfo, ok := t.nodes.Get(addr)
+	if !ok {
+		return
+	}
+	t.nodes.Delete(addr)
+
+	// Let the subscribers know the node no longer has any of its files
+	for _, fileName := range nodeInfo.files.Keys() {
+		t.notifySubscribers(fileName, protocol.NodeLeft, nodeInfo, nil)
+	}
+}
+
+// Pushes a change to the nodes who have a file to every node subscribed to it, except the changed node itself
+func (t *Tracker) notifySubscribers(fileName string, updateType uint8, changed *NodeInfo, bitfield protocol.Bitfield) {
+	packet := protocol.NewNodeUpdatePacket(fileName, updateType, changed.name, changed.udpPort, bitfield)
+
+	for _, node := range t.nodes.Values() {
+		if node == changed || !node.subscriptions.Contains(fileName) {
+			continue
+		}
+
+		node.conn.EnqueuePacket(&packet)
+	}
+}
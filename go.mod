@@ -3,8 +3,12 @@ module PessiTorrent
 go 1.21.1
 
 require (
+	github.com/zeebo/blake3 v0.2.4
 	golang.org/x/term v0.15.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.15.0 // indirect
+require (
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
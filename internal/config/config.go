@@ -23,7 +23,40 @@ type Config struct {
 	} `yaml:"tracker"`
 
 	Node struct {
-		Port uint `yaml:"port"`
+		Port          uint   `yaml:"port"`
+		MerkleTree    bool   `yaml:"merkle_tree"`
+		HashAlgorithm string `yaml:"hash_algorithm"`
+		OnConflict    string `yaml:"on_conflict"`
+		OnChange      string `yaml:"on_change"`
+		Sharing       string `yaml:"sharing"`
+		Selection     string `yaml:"selection"`
+		Storage       string `yaml:"storage"`
+		Preallocate   bool   `yaml:"preallocate"`
+		MaxDownloads  int    `yaml:"max_downloads"` // Downloads active at once, 0 for unlimited
+
+		// Directories whose files are automatically published
+		Watch []string `yaml:"watch"`
+
+		// Transfer rates in bytes per second, 0 for unlimited
+		Limits struct {
+			Upload       uint64 `yaml:"upload"`
+			Download     uint64 `yaml:"download"`
+			PeerUpload   uint64 `yaml:"peer_upload"`
+			PeerDownload uint64 `yaml:"peer_download"`
+			FileUpload   uint64 `yaml:"file_upload"`
+			FileDownload uint64 `yaml:"file_download"`
+		} `yaml:"limits"`
+
+		Discovery struct {
+			Enabled bool   `yaml:"enabled"`
+			Address string `yaml:"address"`
+		} `yaml:"discovery"`
+
+		// Local HTTP endpoint files are streamed on while they are downloaded
+		Stream struct {
+			Enabled bool   `yaml:"enabled"`
+			Address string `yaml:"address"`
+		} `yaml:"stream"`
 	} `yaml:"node"`
 }
 
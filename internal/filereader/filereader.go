@@ -0,0 +1,163 @@
+package filereader
+
+import (
+	"PessiTorrent/internal/structures"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	DefaultMaxHandles = 4 // Open handles per file, i.e. concurrent reads of the same file
+)
+
+var ErrClosed = errors.New("file reader is closed")
+
+// Pool keeps a bounded set of open handles for every file it reads from, so files
+// are not reopened on every read and several reads of the same file can run at once
+type Pool struct {
+	readers    structures.SynchronizedMap[string, *reader]
+	maxHandles int
+}
+
+type reader struct {
+	path    string
+	handles chan *os.File // Idle handles
+	opened  int
+	closed  bool
+	mu      sync.Mutex
+}
+
+func NewPool(maxHandles int) *Pool {
+	return &Pool{
+		readers:    structures.NewSynchronizedMap[string, *reader](),
+		maxHandles: maxHandles,
+	}
+}
+
+// Reads len(buffer) bytes of the file at the given offset. Reaching the end of the file
+// is not an error, the number of bytes read tells how much of the buffer was filled
+func (p *Pool) ReadAt(path string, buffer []byte, offset int64) (int, error) {
+	p.readers.Lock()
+	r, ok := p.readers.M[path]
+	if !ok {
+		r = &reader{path: path, handles: make(chan *os.File, p.maxHandles)}
+		p.readers.M[path] = r
+	}
+	p.readers.Unlock()
+
+	file, err := r.acquire(p.maxHandles)
+	if err != nil {
+		return 0, err
+	}
+	defer r.release(file)
+
+	read, err := file.ReadAt(buffer, offset)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	return read, err
+}
+
+// Closes every handle of the given file, e.g. because it was moved or modified
+func (p *Pool) Close(path string) {
+	p.readers.Lock()
+	r, ok := p.readers.M[path]
+	delete(p.readers.M, path)
+	p.readers.Unlock()
+
+	if ok {
+		r.close()
+	}
+}
+
+func (p *Pool) CloseAll() {
+	for _, path := range p.readers.Keys() {
+		p.Close(path)
+	}
+}
+
+func (r *reader) acquire(maxHandles int) (*os.File, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrClosed
+	}
+
+	select {
+	case file := <-r.handles:
+		r.mu.Unlock()
+		return file, nil
+	default:
+	}
+
+	if r.opened < maxHandles {
+		r.opened++
+		r.mu.Unlock()
+
+		file, err := os.Open(r.path)
+		if err != nil {
+			r.mu.Lock()
+			r.opened--
+			r.mu.Unlock()
+			return nil, err
+		}
+
+		return file, nil
+	}
+	r.mu.Unlock()
+
+	// Every handle is in use, wait for one to be released
+	file, ok := <-r.handles
+	if !ok {
+		return nil, ErrClosed
+	}
+
+	return file, nil
+}
+
+func (r *reader) release(file *os.File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		_ = file.Close()
+		return
+	}
+
+	r.handles <- file // Never blocks, there are never more handles than its capacity
+}
+
+func (r *reader) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+
+	// Handles in use are closed once released
+	close(r.handles)
+	for file := range r.handles {
+		_ = file.Close()
+	}
+}
+
+var buffers = sync.Pool{}
+
+// Returns a buffer of the given size, reusing one that was put back if possible
+func GetBuffer(size int) []byte {
+	if buffer, ok := buffers.Get().(*[]byte); ok && cap(*buffer) >= size {
+		return (*buffer)[:size]
+	}
+
+	return make([]byte, size)
+}
+
+// Makes the buffer available to be reused. It must no longer be used afterwards
+func PutBuffer(buffer []byte) {
+	buffers.Put(&buffer)
+}
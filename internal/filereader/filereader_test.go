@@ -0,0 +1,53 @@
+package filereader
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestPoolReadAt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("Hello, this is a test file content."), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	pool := NewPool(2)
+	defer pool.CloseAll()
+
+	// More concurrent reads than handles
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			buffer := GetBuffer(5)
+			defer PutBuffer(buffer)
+
+			read, err := pool.ReadAt(path, buffer, 7)
+			if err != nil || string(buffer[:read]) != "this " {
+				t.Errorf("Expected to read \"this \", got %q (%v)", buffer[:read], err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Reading past the end only fills part of the buffer
+	buffer := make([]byte, 10)
+	read, err := pool.ReadAt(path, buffer, 30)
+	if err != nil || string(buffer[:read]) != "tent." {
+		t.Errorf("Expected to read \"tent.\", got %q (%v)", buffer[:read], err)
+	}
+
+	// Files are reopened after being closed
+	pool.Close(path)
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	if _, err := pool.ReadAt(path, buffer, 0); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the removed file not to be found, got %v", err)
+	}
+}
@@ -1,27 +1,35 @@
 package filewriter
 
 import (
-	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/storage"
 	"PessiTorrent/internal/utils"
-	"os"
+	"errors"
 	"sync"
+	"syscall"
 )
 
 const (
-	Permissions = 0666
-	Flags       = os.O_WRONLY | os.O_CREATE
-
 	WorkerPoolSize = 10
+	QueueSize      = 64 // Chunks waiting to be written, before new ones are refused
+)
+
+var (
+	ErrQueueFull = errors.New("write queue is full")
+	ErrStopped   = errors.New("file writer is stopped")
 )
 
 type FileWriter struct {
-	file        *os.File
+	storage     storage.Storage
+	filePath    string
 	fileName    string
 	chunkSize   uint64
 	chunksQueue chan Chunk
 	onWrite     func(index uint16)
-	stopChannel chan struct{}
+	onError     func(index uint16, err error)
+	stopped     bool
+	mu          sync.Mutex // Guards the queue from being written to after it is closed
 	workerWg    sync.WaitGroup
+	doneChannel chan struct{}
 }
 
 type Chunk struct {
@@ -29,33 +37,42 @@ type Chunk struct {
 	data  []uint8
 }
 
-func NewFileWriter(fileName string, fileSize uint64, onWrite func(index uint16), filePath string) (*FileWriter, error) {
-	// Create sparse file
-	file, err := os.OpenFile(filePath, Flags, Permissions)
-	if err != nil {
-		return nil, err
-	}
-	_, err = file.Seek(int64(fileSize-1), 0)
-	if err != nil {
-		return nil, err
-	}
-	_, err = file.Write([]uint8{0})
+// onWrite is called once a chunk is written, and onError if it could not be, in which case the chunk must be written again
+func NewFileWriter(fileName string, fileSize uint64, onWrite func(index uint16), onError func(index uint16, err error), store storage.Storage, filePath string) (*FileWriter, error) {
+	// Keeps whatever was already written to the file
+	err := store.Create(filePath, fileSize)
 	if err != nil {
 		return nil, err
 	}
 
 	return &FileWriter{
-		file:        file,
+		storage:     store,
+		filePath:    filePath,
 		fileName:    fileName,
 		chunkSize:   utils.ChunkSize(fileSize),
-		chunksQueue: make(chan Chunk),
+		chunksQueue: make(chan Chunk, QueueSize),
 		onWrite:     onWrite,
-		stopChannel: make(chan struct{}),
+		onError:     onError,
+		doneChannel: make(chan struct{}),
 	}, nil
 }
 
-func (fileWriter *FileWriter) EnqueueChunkToWrite(index uint16, data []uint8) {
-	fileWriter.chunksQueue <- Chunk{index, data}
+// Queues the chunk to be written without blocking. If the disk can not keep up, the chunk is refused
+// with ErrQueueFull and the caller is expected to drop it, so it is requested again later
+func (fileWriter *FileWriter) EnqueueChunkToWrite(index uint16, data []uint8) error {
+	fileWriter.mu.Lock()
+	defer fileWriter.mu.Unlock()
+
+	if fileWriter.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case fileWriter.chunksQueue <- Chunk{index, data}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
 }
 
 func (fileWriter *FileWriter) Start() {
@@ -64,39 +81,44 @@ func (fileWriter *FileWriter) Start() {
 		go fileWriter.workerPool()
 	}
 
-	<-fileWriter.stopChannel
 	fileWriter.workerWg.Wait()
+	fileWriter.storage.Close(fileWriter.filePath)
+	close(fileWriter.doneChannel)
 }
 
 func (fileWriter *FileWriter) workerPool() {
-	for {
-		chunk, ok := <-fileWriter.chunksQueue
-		if !ok {
-			fileWriter.workerWg.Done()
-			return
-		}
+	defer fileWriter.workerWg.Done()
 
+	for chunk := range fileWriter.chunksQueue {
 		fileWriter.writeChunk(chunk)
 	}
 }
 
 func (fileWriter *FileWriter) writeChunk(chunk Chunk) {
-	_, err := fileWriter.file.WriteAt(chunk.data, int64(chunk.index)*int64(fileWriter.chunkSize))
+	err := fileWriter.storage.WriteAt(fileWriter.filePath, chunk.data, int64(chunk.index)*int64(fileWriter.chunkSize))
 	if err != nil {
-		logger.Error("Error writing chunk to file: %v", err)
+		fileWriter.onError(chunk.index, err)
+		return
 	}
 
-	//logger.Info("Chunk of index %d written to file %s", chunk.index, fw.fileName)
 	fileWriter.onWrite(chunk.index)
 }
 
+// Refuses new chunks and waits for the queued ones to be written before closing the file
 func (fileWriter *FileWriter) Stop() {
-	err := fileWriter.file.Close()
-	if err != nil {
-		logger.Error("Error closing file: %v", err)
+	fileWriter.mu.Lock()
+	if fileWriter.stopped {
+		fileWriter.mu.Unlock()
+		return
 	}
-
-	fileWriter.stopChannel <- struct{}{}
+	fileWriter.stopped = true
 	close(fileWriter.chunksQueue)
-	close(fileWriter.stopChannel)
+	fileWriter.mu.Unlock()
+
+	<-fileWriter.doneChannel
+}
+
+// Returns true if the error means there is no space left to write to
+func IsDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
 }
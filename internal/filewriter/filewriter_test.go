@@ -0,0 +1,100 @@
+package filewriter
+
+import (
+	"PessiTorrent/internal/storage"
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// Fails every write with the given error
+type failingStorage struct {
+	*storage.MemoryStorage
+	err error
+}
+
+func (s failingStorage) WriteAt(_ string, _ []byte, _ int64) error {
+	return s.err
+}
+
+func TestFileWriterDrainsBeforeStopping(t *testing.T) {
+	store := storage.NewMemoryStorage()
+
+	var mu sync.Mutex
+	written := make(map[uint16]bool)
+	onWrite := func(index uint16) {
+		mu.Lock()
+		defer mu.Unlock()
+		written[index] = true
+	}
+	onError := func(index uint16, err error) {
+		t.Errorf("Unexpected error writing chunk %d: %v", index, err)
+	}
+
+	writer, err := NewFileWriter("file", 10, onWrite, onError, store, "file")
+	if err != nil {
+		t.Fatalf("Error creating file writer: %v", err)
+	}
+
+	// Chunks are queued before the workers start, and written before Stop returns
+	if err := writer.EnqueueChunkToWrite(0, []byte("0123456789")); err != nil {
+		t.Fatalf("Error enqueuing chunk: %v", err)
+	}
+
+	go writer.Start()
+	writer.Stop()
+
+	if !written[0] {
+		t.Errorf("Expected chunk 0 to be written before stopping")
+	}
+
+	if err := writer.EnqueueChunkToWrite(0, []byte("0123456789")); !errors.Is(err, ErrStopped) {
+		t.Errorf("Expected chunks to be refused after stopping, got %v", err)
+	}
+}
+
+func TestFileWriterQueueFull(t *testing.T) {
+	writer, err := NewFileWriter("file", 10, func(uint16) {}, func(uint16, error) {}, storage.NewMemoryStorage(), "file")
+	if err != nil {
+		t.Fatalf("Error creating file writer: %v", err)
+	}
+
+	// Workers are not started, so nothing leaves the queue
+	for i := 0; i < QueueSize; i++ {
+		if err := writer.EnqueueChunkToWrite(0, []byte("0123456789")); err != nil {
+			t.Fatalf("Error enqueuing chunk %d: %v", i, err)
+		}
+	}
+
+	if err := writer.EnqueueChunkToWrite(0, []byte("0123456789")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected a full queue to refuse chunks, got %v", err)
+	}
+}
+
+func TestFileWriterReportsErrors(t *testing.T) {
+	store := failingStorage{storage.NewMemoryStorage(), syscall.ENOSPC}
+
+	var failed error
+	onWrite := func(index uint16) {
+		t.Errorf("Chunk %d should not be reported as written", index)
+	}
+	onError := func(index uint16, err error) {
+		failed = err
+	}
+
+	writer, err := NewFileWriter("file", 10, onWrite, onError, store, "file")
+	if err != nil {
+		t.Fatalf("Error creating file writer: %v", err)
+	}
+
+	go writer.Start()
+	if err := writer.EnqueueChunkToWrite(0, []byte("0123456789")); err != nil {
+		t.Fatalf("Error enqueuing chunk: %v", err)
+	}
+	writer.Stop()
+
+	if !IsDiskFull(failed) {
+		t.Errorf("Expected the disk full error to be reported, got %v", failed)
+	}
+}
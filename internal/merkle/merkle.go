@@ -0,0 +1,111 @@
+package merkle
+
+import (
+	"bytes"
+	"hash"
+)
+
+// Tree is a binary Merkle tree built over the chunk hashes of a file, using the same hash function as the chunks.
+// When a level has an odd number of nodes, the last one is promoted to the next level unchanged
+type Tree struct {
+	levels [][][]byte // levels[0] holds the leaves and the last level holds the root
+}
+
+func NewTree(leaves [][]byte, newHash func() hash.Hash) Tree {
+	level := make([][]byte, len(leaves))
+	copy(level, leaves)
+
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, hashNodes(newHash, level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+
+		levels = append(levels, next)
+		level = next
+	}
+
+	return Tree{levels: levels}
+}
+
+func (t *Tree) Root() []byte {
+	root := t.levels[len(t.levels)-1]
+	if len(root) == 0 {
+		return nil
+	}
+
+	return root[0]
+}
+
+func (t *Tree) NumberOfLeaves() int {
+	return len(t.levels[0])
+}
+
+// Returns the sibling hashes needed to recompute the root from the given leaf, from the bottom up
+func (t *Tree) Proof(index uint16) [][]byte {
+	proof := make([][]byte, 0)
+	if int(index) >= t.NumberOfLeaves() {
+		return proof
+	}
+
+	position := int(index)
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := position ^ 1
+		if sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+
+		position /= 2
+	}
+
+	return proof
+}
+
+// Returns true if the leaf, at the given index of a tree with the given number of leaves, belongs to the tree with the given root
+func Verify(newHash func() hash.Hash, root []byte, leaf []byte, index uint16, numberOfLeaves uint16, proof [][]byte) bool {
+	if index >= numberOfLeaves {
+		return false
+	}
+
+	node := leaf
+	position := int(index)
+	width := int(numberOfLeaves)
+	used := 0
+
+	for width > 1 {
+		sibling := position ^ 1
+		if sibling < width {
+			if used >= len(proof) {
+				return false
+			}
+
+			if position%2 == 0 {
+				node = hashNodes(newHash, node, proof[used])
+			} else {
+				node = hashNodes(newHash, proof[used], node)
+			}
+			used++
+		}
+
+		position /= 2
+		width = (width + 1) / 2
+	}
+
+	return used == len(proof) && bytes.Equal(node, root)
+}
+
+// Internal nodes are prefixed to tell them apart from chunk hashes. Leaves are not prefixed, so it is
+// the fixed number of leaves and the proof length checked by Verify that keep a node from passing as a leaf
+func hashNodes(newHash func() hash.Hash, left []byte, right []byte) []byte {
+	h := newHash()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,85 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+)
+
+func testLeaves(size int) [][]byte {
+	leaves := make([][]byte, size)
+	for i := range leaves {
+		hash := sha1.Sum([]byte{byte(i), byte(i >> 8)})
+		leaves[i] = hash[:]
+	}
+
+	return leaves
+}
+
+func TestVerifyProofs(t *testing.T) {
+	for _, size := range []int{1, 2, 3, 5, 8, 13, 100} {
+		leaves := testLeaves(size)
+		tree := NewTree(leaves, sha1.New)
+
+		for i, leaf := range leaves {
+			proof := tree.Proof(uint16(i))
+			if !Verify(sha1.New, tree.Root(), leaf, uint16(i), uint16(size), proof) {
+				t.Errorf("Tree of %d leaves: proof of leaf %d did not verify", size, i)
+			}
+		}
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	leaves := testLeaves(7)
+	tree := NewTree(leaves, sha1.New)
+	proof := tree.Proof(3)
+
+	if Verify(sha1.New, tree.Root(), leaves[4], 3, 7, proof) {
+		t.Errorf("Expected wrong leaf to be rejected")
+	}
+
+	if Verify(sha1.New, tree.Root(), leaves[3], 2, 7, proof) {
+		t.Errorf("Expected wrong index to be rejected")
+	}
+
+	if Verify(sha1.New, tree.Root(), leaves[3], 3, 7, proof[:len(proof)-1]) {
+		t.Errorf("Expected truncated proof to be rejected")
+	}
+
+	if Verify(sha1.New, tree.Root(), leaves[3], 7, 7, proof) {
+		t.Errorf("Expected out of bounds index to be rejected")
+	}
+}
+
+func TestSingleLeafRoot(t *testing.T) {
+	leaves := testLeaves(1)
+	tree := NewTree(leaves, sha1.New)
+
+	if !bytes.Equal(tree.Root(), leaves[0]) {
+		t.Errorf("Expected root of a single leaf tree to be the leaf itself")
+	}
+
+	if len(tree.Proof(0)) != 0 {
+		t.Errorf("Expected empty proof for a single leaf tree")
+	}
+}
+
+func TestTreeUsesGivenHash(t *testing.T) {
+	leaves := testLeaves(4)
+	sha1Tree := NewTree(leaves, sha1.New)
+	sha256Tree := NewTree(leaves, sha256.New)
+
+	if len(sha256Tree.Root()) != sha256.Size {
+		t.Errorf("Expected root of %d bytes, got %d", sha256.Size, len(sha256Tree.Root()))
+	}
+
+	if Verify(sha1.New, sha256Tree.Root(), leaves[0], 0, 4, sha256Tree.Proof(0)) {
+		t.Errorf("Expected proof to be rejected with a different hash function")
+	}
+
+	if !Verify(sha1.New, sha1Tree.Root(), leaves[0], 0, 4, sha1Tree.Proof(0)) {
+		t.Errorf("Expected proof to verify with the tree's hash function")
+	}
+}
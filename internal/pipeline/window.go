@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	InitialSize    = 4 // Chunks requested from a peer before anything is known about it
+	MinSize        = 1
+	MaxSize        = 512
+	InitialTimeout = time.Second
+	MinTimeout     = 100 * time.Millisecond
+	MaxTimeout     = 10 * time.Second
+)
+
+// Window is how many chunks can be requested from a peer at once, and how long to wait for each of them.
+// It grows with every chunk the peer delivers, up to twice the chunks it delivers in its fastest round trip,
+// and is halved when requests time out. Timeouts follow the round trip times, as in TCP
+type Window struct {
+	size float64
+
+	srtt   time.Duration // Smoothed round trip time
+	rttvar time.Duration // Round trip time variation
+	minRTT time.Duration
+	rto    time.Duration
+
+	rate         float64 // Smoothed chunks delivered per second
+	lastReceived time.Time
+
+	mu sync.Mutex
+}
+
+func NewWindow() *Window {
+	return &Window{
+		size: InitialSize,
+		rto:  InitialTimeout,
+	}
+}
+
+// Returns how many chunks can be requested at once
+func (w *Window) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return int(w.size)
+}
+
+// Returns how many more chunks can be requested, given how many are still waited for
+func (w *Window) Available(inFlight int) int {
+	return max(0, w.Size()-inFlight)
+}
+
+// Returns how long to wait for a requested chunk before requesting it again
+func (w *Window) Timeout() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.rto
+}
+
+// Returns the smoothed round trip time, or zero if no chunk was received yet
+func (w *Window) RTT() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.srtt
+}
+
+// Records a chunk that arrived rtt after it was requested
+func (w *Window) OnReceived(rtt time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.updateRTT(rtt)
+	w.updateRate(time.Now())
+
+	w.size = math.Min(w.size+1, w.maxSize())
+}
+
+// Records that requested chunks were not received in time
+func (w *Window) OnTimeout() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.size = math.Max(MinSize, math.Floor(w.size/2))
+
+	// Back off until a new round trip time is measured
+	w.rto = min(MaxTimeout, 2*w.rto)
+}
+
+// Must be called with the lock held
+func (w *Window) updateRTT(rtt time.Duration) {
+	if w.srtt == 0 {
+		w.srtt = rtt
+		w.rttvar = rtt / 2
+		w.minRTT = rtt
+	} else {
+		w.rttvar = (3*w.rttvar + (w.srtt - rtt).Abs()) / 4
+		w.srtt = (7*w.srtt + rtt) / 8
+		w.minRTT = min(w.minRTT, rtt)
+	}
+
+	w.rto = max(MinTimeout, min(MaxTimeout, w.srtt+4*w.rttvar))
+}
+
+// Must be called with the lock held
+func (w *Window) updateRate(now time.Time) {
+	interval := now.Sub(w.lastReceived)
+	w.lastReceived = now
+
+	// Gaps where nothing was requested say nothing about the peer
+	if interval <= 0 || interval > w.rto {
+		return
+	}
+
+	sample := 1 / interval.Seconds()
+	if w.rate == 0 {
+		w.rate = sample
+	} else {
+		w.rate = (7*w.rate + sample) / 8
+	}
+}
+
+// Returns the largest the window can grow to. Once the peer delivers chunks as fast as it can,
+// requesting more only makes them wait longer in its queue. Must be called with the lock held
+func (w *Window) maxSize() float64 {
+	if w.rate == 0 {
+		return MaxSize
+	}
+
+	bandwidthDelay := w.rate * w.minRTT.Seconds()
+	return math.Max(InitialSize, math.Min(MaxSize, math.Ceil(2*bandwidthDelay)))
+}
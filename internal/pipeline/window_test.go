@@ -0,0 +1,72 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowGrowsWithReceivedChunks(t *testing.T) {
+	window := NewWindow()
+
+	for i := 0; i < 10; i++ {
+		window.OnReceived(10 * time.Millisecond)
+	}
+
+	if size := window.Size(); size <= InitialSize {
+		t.Errorf("Expected the window to grow past %d, got %d", InitialSize, size)
+	}
+}
+
+func TestWindowShrinksOnTimeout(t *testing.T) {
+	window := NewWindow()
+	for i := 0; i < 10; i++ {
+		window.OnReceived(10 * time.Millisecond)
+	}
+	size := window.Size()
+
+	window.OnTimeout()
+	if window.Size() != size/2 {
+		t.Errorf("Expected the window to be halved to %d, got %d", size/2, window.Size())
+	}
+
+	for i := 0; i < 20; i++ {
+		window.OnTimeout()
+	}
+	if window.Size() != MinSize {
+		t.Errorf("Expected the window to stay at %d, got %d", MinSize, window.Size())
+	}
+}
+
+func TestWindowTimeoutFollowsRTT(t *testing.T) {
+	window := NewWindow()
+	if window.Timeout() != InitialTimeout {
+		t.Errorf("Expected timeout %s, got %s", InitialTimeout, window.Timeout())
+	}
+
+	for i := 0; i < 20; i++ {
+		window.OnReceived(200 * time.Millisecond)
+	}
+
+	// Steady round trips leave little variation on top of them
+	timeout := window.Timeout()
+	if timeout < 200*time.Millisecond || timeout > 400*time.Millisecond {
+		t.Errorf("Expected timeout close to 200ms, got %s", timeout)
+	}
+
+	window.OnTimeout()
+	if window.Timeout() != 2*timeout {
+		t.Errorf("Expected timeout to back off to %s, got %s", 2*timeout, window.Timeout())
+	}
+}
+
+func TestWindowAvailable(t *testing.T) {
+	window := NewWindow()
+
+	if available := window.Available(1); available != InitialSize-1 {
+		t.Errorf("Expected %d chunks available, got %d", InitialSize-1, available)
+	}
+
+	if available := window.Available(InitialSize + 2); available != 0 {
+		t.Errorf("Expected no chunks available, got %d", available)
+	}
+}
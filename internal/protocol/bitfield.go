@@ -1,5 +1,7 @@
 package protocol
 
+import "sort"
+
 type Bitfield = []uint8
 
 func EncodeBitField(bitfield []bool) Bitfield {
@@ -57,3 +59,45 @@ func GetBit(bitfield []uint8, position int) bool {
 
 	return (value & mask) == mask
 }
+
+// ChunkRange is an inclusive range of chunk indexes
+type ChunkRange struct {
+	Start uint16
+	End   uint16
+}
+
+// Run-length encodes the given chunk indexes into the smallest list of ranges
+func EncodeChunkRanges(chunks []uint16) []ChunkRange {
+	sorted := make([]uint16, len(chunks))
+	copy(sorted, chunks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ranges := make([]ChunkRange, 0)
+	for _, chunk := range sorted {
+		last := len(ranges) - 1
+		if last >= 0 && int(chunk) <= int(ranges[last].End)+1 {
+			if chunk > ranges[last].End {
+				ranges[last].End = chunk
+			}
+			continue
+		}
+
+		ranges = append(ranges, ChunkRange{Start: chunk, End: chunk})
+	}
+
+	return ranges
+}
+
+// Returns a copy of the bitfield with every chunk in the given ranges set, ignoring chunks out of bounds
+func ApplyChunkRanges(bitfield Bitfield, ranges []ChunkRange) Bitfield {
+	result := make(Bitfield, len(bitfield))
+	copy(result, bitfield)
+
+	for _, chunkRange := range ranges {
+		for chunk := int(chunkRange.Start); chunk <= int(chunkRange.End) && chunk < len(result)*8; chunk++ {
+			SetBit(result, chunk)
+		}
+	}
+
+	return result
+}
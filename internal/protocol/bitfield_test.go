@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncodeDecodeBitField(t *testing.T) {
+	bitfield := []bool{true, false, true, true, false, false, false, true, true}
+
+	decoded := DecodeBitField(EncodeBitField(bitfield))
+	if !reflect.DeepEqual(decoded[:len(bitfield)], bitfield) {
+		t.Errorf("Expected %v, got %v", bitfield, decoded[:len(bitfield)])
+	}
+}
+
+func TestEncodeChunkRanges(t *testing.T) {
+	testCases := []struct {
+		chunks   []uint16
+		expected []ChunkRange
+	}{
+		{[]uint16{}, []ChunkRange{}},
+		{[]uint16{4}, []ChunkRange{{4, 4}}},
+		{[]uint16{3, 1, 2, 7, 8, 10}, []ChunkRange{{1, 3}, {7, 8}, {10, 10}}},
+		{[]uint16{5, 5, 6}, []ChunkRange{{5, 6}}},
+		{[]uint16{0, 65535}, []ChunkRange{{0, 0}, {65535, 65535}}},
+	}
+
+	for _, tc := range testCases {
+		result := EncodeChunkRanges(tc.chunks)
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("EncodeChunkRanges(%v): expected %v, got %v", tc.chunks, tc.expected, result)
+		}
+	}
+}
+
+func TestApplyChunkRanges(t *testing.T) {
+	bitfield := EncodeBitField(make([]bool, 10))
+
+	result := ApplyChunkRanges(bitfield, []ChunkRange{{1, 3}, {9, 20}})
+	expected := []bool{false, true, true, true, false, false, false, false, false, true}
+
+	if decoded := DecodeBitField(result)[:len(expected)]; !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %v, got %v", expected, decoded)
+	}
+
+	if GetBit(bitfield, 1) {
+		t.Errorf("ApplyChunkRanges should not modify the original bitfield")
+	}
+}
@@ -21,20 +21,44 @@ func (ip *InitPacket) GetPacketType() uint8 {
 	return InitType
 }
 
-// PublishFilePacket is sent by the node to the tracker when it wants to publish a file
+const (
+	FlatHashMode   = 0 // Every chunk hash is sent to the tracker and to the downloading nodes
+	MerkleHashMode = 1 // Only the Merkle root is, chunks are sent along with their inclusion proofs
+)
+
+// PublishFilePacket is sent by the node to the tracker when it wants to publish a file.
+// Every hash is a digest of the given HashAlgorithm
 type PublishFilePacket struct {
-	FileName    string
-	FileSize    uint64
-	FileHash    [20]byte
-	ChunkHashes [][20]byte
+	FileName      string
+	FileSize      uint64
+	HashAlgorithm uint8
+	FileHash      []byte
+	HashMode      uint8
+	MerkleRoot    []byte
+	ChunkHashes   [][]byte
+}
+
+func NewPublishFilePacket(fileName string, fileSize uint64, hashAlgorithm uint8, fileHash []byte, chunkHashes [][]byte) PublishFilePacket {
+	return PublishFilePacket{
+		FileName:      fileName,
+		FileSize:      fileSize,
+		HashAlgorithm: hashAlgorithm,
+		FileHash:      fileHash,
+		HashMode:      FlatHashMode,
+		MerkleRoot:    make([]byte, 0),
+		ChunkHashes:   chunkHashes,
+	}
 }
 
-func NewPublishFilePacket(fileName string, fileSize uint64, fileHash [20]byte, chunkHashes [][20]byte) PublishFilePacket {
+func NewMerklePublishFilePacket(fileName string, fileSize uint64, hashAlgorithm uint8, fileHash []byte, merkleRoot []byte) PublishFilePacket {
 	return PublishFilePacket{
-		FileName:    fileName,
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		HashAlgorithm: hashAlgorithm,
+		FileHash:      fileHash,
+		HashMode:      MerkleHashMode,
+		MerkleRoot:    merkleRoot,
+		ChunkHashes:   make([][]byte, 0),
 	}
 }
 
@@ -59,6 +83,24 @@ func (pc *UpdateChunksPacket) GetPacketType() uint8 {
 	return UpdateChunksType
 }
 
+// UpdateChunksDeltaPacket is sent by the node to the tracker, after its first UpdateChunksPacket of a file,
+// with only the chunks it acquired since its last update
+type UpdateChunksDeltaPacket struct {
+	FileName string
+	Ranges   []ChunkRange
+}
+
+func NewUpdateChunksDeltaPacket(fileName string, ranges []ChunkRange) UpdateChunksDeltaPacket {
+	return UpdateChunksDeltaPacket{
+		FileName: fileName,
+		Ranges:   ranges,
+	}
+}
+
+func (uc *UpdateChunksDeltaPacket) GetPacketType() uint8 {
+	return UpdateChunksDeltaType
+}
+
 // RequestFilePacket is sent by the node to the tracker when it wants to download a file to get information about the file
 type RequestFilePacket struct {
 	FileName string
@@ -88,6 +130,36 @@ func (uf *UpdateFilePacket) GetPacketType() uint8 {
 	return UpdateFileType
 }
 
+// SubscribeFilePacket is sent by the node to the tracker when it wants to be notified about changes to the nodes who have a file
+type SubscribeFilePacket struct {
+	FileName string
+}
+
+func NewSubscribeFilePacket(fileName string) SubscribeFilePacket {
+	return SubscribeFilePacket{
+		FileName: fileName,
+	}
+}
+
+func (sf *SubscribeFilePacket) GetPacketType() uint8 {
+	return SubscribeFileType
+}
+
+// UnsubscribeFilePacket is sent by the node to the tracker when it no longer wants to be notified about a file
+type UnsubscribeFilePacket struct {
+	FileName string
+}
+
+func NewUnsubscribeFilePacket(fileName string) UnsubscribeFilePacket {
+	return UnsubscribeFilePacket{
+		FileName: fileName,
+	}
+}
+
+func (uf *UnsubscribeFilePacket) GetPacketType() uint8 {
+	return UnsubscribeFileType
+}
+
 // TRACKER -> NODE
 
 // FileSuccessPacket is sent by the tracker to the node when it
@@ -145,13 +217,33 @@ func (nf *NotFoundPacket) GetPacketType() uint8 {
 	return NotFoundType
 }
 
+// InvalidPublishPacket is sent by the tracker to the node when the file it wants to publish has hashes the tracker can not use
+type InvalidPublishPacket struct {
+	FileName string
+	Reason   string
+}
+
+func NewInvalidPublishPacket(fileName string, reason string) InvalidPublishPacket {
+	return InvalidPublishPacket{
+		FileName: fileName,
+		Reason:   reason,
+	}
+}
+
+func (ip *InvalidPublishPacket) GetPacketType() uint8 {
+	return InvalidPublishType
+}
+
 // AnswerFileWithNodesPacket is sent by the tracker to the node when it wants to download a file to give information about the file
 type AnswerFileWithNodesPacket struct {
-	FileName    string
-	FileSize    uint64
-	FileHash    [20]byte
-	ChunkHashes [][20]byte
-	Nodes       []NodeFileInfo
+	FileName      string
+	FileSize      uint64
+	HashAlgorithm uint8
+	FileHash      []byte
+	HashMode      uint8
+	MerkleRoot    []byte
+	ChunkHashes   [][]byte // Empty if HashMode is MerkleHashMode
+	Nodes         []NodeFileInfo
 }
 
 type NodeFileInfo struct {
@@ -160,12 +252,15 @@ type NodeFileInfo struct {
 	Bitfield []uint8
 }
 
-func NewAnswerFileWithNodesPacket(fileName string, fileSize uint64, fileHash [20]byte, chunkHashes [][20]byte, names []string, ports []uint16, bitfields []Bitfield) AnswerFileWithNodesPacket {
+func NewAnswerFileWithNodesPacket(fileName string, fileSize uint64, hashAlgorithm uint8, fileHash []byte, hashMode uint8, merkleRoot []byte, chunkHashes [][]byte, names []string, ports []uint16, bitfields []Bitfield) AnswerFileWithNodesPacket {
 	an := AnswerFileWithNodesPacket{
-		FileName:    fileName,
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		HashAlgorithm: hashAlgorithm,
+		FileHash:      fileHash,
+		HashMode:      hashMode,
+		MerkleRoot:    merkleRoot,
+		ChunkHashes:   chunkHashes,
 	}
 
 	for i := 0; i < len(bitfields); i++ {
@@ -214,6 +309,36 @@ func (an *AnswerNodesPacket) GetPacketType() uint8 {
 	return AnswerNodesType
 }
 
+const (
+	NodeJoined  = 0
+	NodeLeft    = 1
+	NodeUpdated = 2
+)
+
+// NodeUpdatePacket is pushed by the tracker to the nodes subscribed to a file
+// when a node joins(Type = NodeJoined)/leaves(Type = NodeLeft) it or its bitfield changes(Type = NodeUpdated)
+type NodeUpdatePacket struct {
+	FileName string
+	Type     uint8
+	Node     NodeFileInfo
+}
+
+func NewNodeUpdatePacket(fileName string, updateType uint8, name string, port uint16, bitfield Bitfield) NodeUpdatePacket {
+	return NodeUpdatePacket{
+		FileName: fileName,
+		Type:     updateType,
+		Node: NodeFileInfo{
+			Name:     name,
+			Port:     port,
+			Bitfield: bitfield,
+		},
+	}
+}
+
+func (nu *NodeUpdatePacket) GetPacketType() uint8 {
+	return NodeUpdateType
+}
+
 type RemoveFilePacket struct {
 	FileName string
 }
@@ -250,16 +375,189 @@ type ChunkPacket struct {
 	FileName     string
 	Chunk        uint16
 	ChunkContent []uint8
+	Proof        [][]byte // Merkle inclusion proof, empty if the file was published with FlatHashMode
 }
 
-func NewChunkPacket(fileName string, chunk uint16, chunkContent []uint8) ChunkPacket {
+func NewChunkPacket(fileName string, chunk uint16, chunkContent []uint8, proof [][]byte) ChunkPacket {
 	return ChunkPacket{
 		FileName:     fileName,
 		Chunk:        chunk,
 		ChunkContent: chunkContent,
+		Proof:        proof,
 	}
 }
 
 func (c *ChunkPacket) GetPacketType() uint8 {
 	return ChunkType
 }
+
+const (
+	PeerExchangeRequest = 0 // Expects a PeerExchangePacket back
+	PeerExchangeAnswer  = 1
+)
+
+// PeerExchangePacket is sent by a node to the nodes it is exchanging a file with,
+// to share its own bitfield and the nodes it knows have chunks of that file
+type PeerExchangePacket struct {
+	FileName string
+	Type     uint8
+	Bitfield Bitfield
+	Peers    []PeerFileInfo
+}
+
+type PeerFileInfo struct {
+	IPAddr   [4]byte
+	Port     uint16
+	Bitfield Bitfield
+}
+
+func NewPeerExchangePacket(fileName string, packetType uint8, bitfield Bitfield, peers []PeerFileInfo) PeerExchangePacket {
+	return PeerExchangePacket{
+		FileName: fileName,
+		Type:     packetType,
+		Bitfield: bitfield,
+		Peers:    peers,
+	}
+}
+
+// Returns a packet with as many of the peers as fit in maxSize bytes once serialized, in the order they are given
+func NewBoundedPeerExchangePacket(fileName string, packetType uint8, bitfield Bitfield, peers []PeerFileInfo, maxSize int) PeerExchangePacket {
+	// Packet type, file name, exchange type, bitfield and number of peers
+	size := 1 + 4 + len(fileName) + 1 + 4 + len(bitfield) + 4
+
+	for i, peer := range peers {
+		// Address, port and bitfield
+		size += len(peer.IPAddr) + 2 + 4 + len(peer.Bitfield)
+		if size > maxSize {
+			peers = peers[:i]
+			break
+		}
+	}
+
+	return NewPeerExchangePacket(fileName, packetType, bitfield, peers)
+}
+
+func (pe *PeerExchangePacket) GetPacketType() uint8 {
+	return PeerExchangeType
+}
+
+// HavePacket is sent by a node to the nodes it is exchanging a file with, when it has finished downloading new chunks of it
+type HavePacket struct {
+	FileName string
+	Chunks   []uint16
+}
+
+func NewHavePacket(fileName string, chunks []uint16) HavePacket {
+	return HavePacket{
+		FileName: fileName,
+		Chunks:   chunks,
+	}
+}
+
+// Splits the chunks across as many packets as needed for each to take at most maxSize bytes once serialized
+func NewHavePackets(fileName string, chunks []uint16, maxSize int) []HavePacket {
+	// Packet type, file name and number of chunks, followed by 2 bytes per chunk
+	perPacket := max(1, (maxSize-1-4-len(fileName)-4)/2)
+
+	packets := make([]HavePacket, 0, len(chunks)/perPacket+1)
+	for len(chunks) > perPacket {
+		packets = append(packets, NewHavePacket(fileName, chunks[:perPacket]))
+		chunks = chunks[perPacket:]
+	}
+
+	return append(packets, NewHavePacket(fileName, chunks))
+}
+
+func (h *HavePacket) GetPacketType() uint8 {
+	return HaveType
+}
+
+// ChokePacket is sent by a node to a node it will no longer upload a file to, until it is unchoked
+type ChokePacket struct {
+	FileName string
+}
+
+func NewChokePacket(fileName string) ChokePacket {
+	return ChokePacket{
+		FileName: fileName,
+	}
+}
+
+func (c *ChokePacket) GetPacketType() uint8 {
+	return ChokeType
+}
+
+// UnchokePacket is sent by a node to a node it was not uploading a file to, when it has an upload slot for it again
+type UnchokePacket struct {
+	FileName string
+}
+
+func NewUnchokePacket(fileName string) UnchokePacket {
+	return UnchokePacket{
+		FileName: fileName,
+	}
+}
+
+func (u *UnchokePacket) GetPacketType() uint8 {
+	return UnchokeType
+}
+
+const (
+	RejectNotShared         = 0 // The file is not shared with other nodes
+	RejectUnavailableChunks = 1 // The chunks are out of bounds or not downloaded yet
+)
+
+// RejectPacket is sent by a node to a node that requested chunks of a file it does not offer
+type RejectPacket struct {
+	FileName string
+	Reason   uint8
+	Chunks   []uint16
+}
+
+func NewRejectPacket(fileName string, reason uint8, chunks []uint16) RejectPacket {
+	return RejectPacket{
+		FileName: fileName,
+		Reason:   reason,
+		Chunks:   chunks,
+	}
+}
+
+func (r *RejectPacket) GetPacketType() uint8 {
+	return RejectType
+}
+
+// CancelPacket is sent by a node to a node it requested chunks from, when it no longer needs them
+type CancelPacket struct {
+	FileName string
+	Chunks   []uint16
+}
+
+func NewCancelPacket(fileName string, chunks []uint16) CancelPacket {
+	return CancelPacket{
+		FileName: fileName,
+		Chunks:   chunks,
+	}
+}
+
+func (c *CancelPacket) GetPacketType() uint8 {
+	return CancelType
+}
+
+// NODE -> NODES (multicast)
+
+// AnnouncePacket is multicast by a node to the nodes on the same subnet to announce the files it is seeding
+type AnnouncePacket struct {
+	UDPPort    uint16
+	FileHashes [][]byte
+}
+
+func NewAnnouncePacket(udpPort uint16, fileHashes [][]byte) AnnouncePacket {
+	return AnnouncePacket{
+		UDPPort:    udpPort,
+		FileHashes: fileHashes,
+	}
+}
+
+func (a *AnnouncePacket) GetPacketType() uint8 {
+	return AnnounceType
+}
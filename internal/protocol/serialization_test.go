@@ -62,12 +62,26 @@ func TestBytes(t *testing.T) {
 
 func TestSerialize(t *testing.T) {
 	// create dummy PublishFilePacket
-	packet := NewPublishFilePacket("test.txt", 6, [20]byte{1, 2, 3, 4, 5}, [][20]byte{{6, 7, 8}, {9, 10, 11}})
+	packet := NewPublishFilePacket("test.txt", 6, 0, []byte{1, 2, 3, 4, 5}, [][]byte{{6, 7, 8}, {9, 10, 11}})
 
 	var deserialize PublishFilePacket
 	testSerializeStruct(&packet, &deserialize, t)
 	checkEquals(packet, deserialize, t)
 
+	// create dummy Merkle PublishFilePacket
+	merklePacket := NewMerklePublishFilePacket("test.txt", 6, 1, []byte{1, 2, 3, 4, 5}, []byte{6, 7, 8})
+
+	var deserializeMerkle PublishFilePacket
+	testSerializeStruct(&merklePacket, &deserializeMerkle, t)
+	checkEquals(merklePacket, deserializeMerkle, t)
+
+	// create dummy ChunkPacket
+	chunkPacket := NewChunkPacket("test.txt", 3, []uint8{1, 2, 3}, [][]byte{{4, 5, 6}, {7, 8, 9}})
+
+	var deserializeChunk ChunkPacket
+	testSerializeStruct(&chunkPacket, &deserializeChunk, t)
+	checkEquals(chunkPacket, deserializeChunk, t)
+
 	// create dummy InitPacket
 	initPacket := NewInitPacket("portatil1.local", 1234)
 
@@ -83,7 +97,7 @@ func TestSerialize(t *testing.T) {
 	checkEquals(publishChunkPacket, deserializePublishChunk, t)
 
 	// create dummy AnswerNodesPacket
-	answerNodesPacket := NewAnswerFileWithNodesPacket("filename.txt", 5, [20]byte{1, 2, 3, 4, 5}, [][20]byte{{6, 7, 8}, {9, 10, 11}}, []string{"portatil1.local"}, []uint16{1, 2, 3, 4, 5}, []Bitfield{EncodeBitField([]bool{true, true, true, true, true})})
+	answerNodesPacket := NewAnswerFileWithNodesPacket("filename.txt", 5, 0, []byte{1, 2, 3, 4, 5}, FlatHashMode, []byte{}, [][]byte{{6, 7, 8}, {9, 10, 11}}, []string{"portatil1.local"}, []uint16{1, 2, 3, 4, 5}, []Bitfield{EncodeBitField([]bool{true, true, true, true, true})})
 
 	var deserializeAnswerNodes AnswerFileWithNodesPacket
 	testSerializeStruct(&answerNodesPacket, &deserializeAnswerNodes, t)
@@ -116,3 +130,117 @@ func TestSerializeStructInsideStruct(t *testing.T) {
 	testSerializeStruct(&struc, &deserialize, t)
 	checkEquals(struc, deserialize, t)
 }
+
+func TestSerializePeerExchange(t *testing.T) {
+	peers := []PeerFileInfo{
+		{IPAddr: [4]byte{10, 0, 0, 1}, Port: 8081, Bitfield: EncodeBitField([]bool{true, false, true})},
+		{IPAddr: [4]byte{10, 0, 0, 2}, Port: 8082, Bitfield: EncodeBitField([]bool{false, true, true})},
+	}
+	packet := NewPeerExchangePacket("test.txt", PeerExchangeRequest, EncodeBitField([]bool{true, true, false}), peers)
+
+	var deserialize PeerExchangePacket
+	testSerializeStruct(&packet, &deserialize, t)
+	checkEquals(packet, deserialize, t)
+}
+
+func TestSerializeNodeUpdate(t *testing.T) {
+	packet := NewNodeUpdatePacket("test.txt", NodeUpdated, "portatil1.local", 8081, EncodeBitField([]bool{true, false, true}))
+
+	var deserialize NodeUpdatePacket
+	testSerializeStruct(&packet, &deserialize, t)
+	checkEquals(packet, deserialize, t)
+}
+
+func TestSerializeChoke(t *testing.T) {
+	packet := NewChokePacket("test.txt")
+
+	buffer := new(bytes.Buffer)
+	err := SerializePacket(buffer, &packet)
+	if err != nil {
+		t.Fatalf("Error serializing packet: %v", err)
+	}
+
+	deserialized, err := DeserializePacket(buffer)
+	if err != nil {
+		t.Fatalf("Error deserializing packet: %v", err)
+	}
+
+	choke, ok := deserialized.(*ChokePacket)
+	if !ok || choke.FileName != packet.FileName {
+		t.Errorf("Expected %v, got %v", packet, deserialized)
+	}
+}
+
+func TestSerializeReject(t *testing.T) {
+	packet := NewRejectPacket("test.txt", RejectUnavailableChunks, []uint16{1, 5, 9})
+
+	var deserialize RejectPacket
+	testSerializeStruct(&packet, &deserialize, t)
+	checkEquals(packet, deserialize, t)
+}
+
+func TestSerializeCancel(t *testing.T) {
+	packet := NewCancelPacket("test.txt", []uint16{2, 7})
+
+	var deserialize CancelPacket
+	testSerializeStruct(&packet, &deserialize, t)
+	checkEquals(packet, deserialize, t)
+}
+
+func TestHavePacketsFitMaxSize(t *testing.T) {
+	chunks := make([]uint16, 100)
+	for i := range chunks {
+		chunks[i] = uint16(i)
+	}
+
+	const maxSize = 64
+	packets := NewHavePackets("test.txt", chunks, maxSize)
+
+	split := make([]uint16, 0)
+	for _, packet := range packets {
+		buffer := new(bytes.Buffer)
+		if err := SerializePacket(buffer, &packet); err != nil {
+			t.Fatalf("Error serializing packet: %v", err)
+		}
+		if buffer.Len() > maxSize {
+			t.Errorf("Expected packets of at most %d bytes, got %d", maxSize, buffer.Len())
+		}
+
+		split = append(split, packet.Chunks...)
+	}
+
+	if !reflect.DeepEqual(split, chunks) {
+		t.Errorf("Expected every chunk to be in some packet, got %v", split)
+	}
+}
+
+func TestBoundedPeerExchangeFitsMaxSize(t *testing.T) {
+	peers := make([]PeerFileInfo, 10)
+	for i := range peers {
+		peers[i] = PeerFileInfo{IPAddr: [4]byte{10, 0, 0, byte(i)}, Port: 8081, Bitfield: make(Bitfield, 100)}
+	}
+
+	const maxSize = 500
+	packet := NewBoundedPeerExchangePacket("test.txt", PeerExchangeRequest, make(Bitfield, 100), peers, maxSize)
+
+	buffer := new(bytes.Buffer)
+	if err := SerializePacket(buffer, &packet); err != nil {
+		t.Fatalf("Error serializing packet: %v", err)
+	}
+	if buffer.Len() > maxSize {
+		t.Errorf("Expected a packet of at most %d bytes, got %d", maxSize, buffer.Len())
+	}
+
+	// Another peer would not fit
+	if len(packet.Peers) != 3 {
+		t.Errorf("Expected 3 peers to fit, got %d", len(packet.Peers))
+	}
+}
+
+func TestSerializeInvalidPublish(t *testing.T) {
+	packet := NewInvalidPublishPacket("test.txt", "unsupported hash algorithm 9")
+
+	var deserialize InvalidPublishPacket
+	testSerializeStruct(&packet, &deserialize, t)
+	checkEquals(packet, deserialize, t)
+}
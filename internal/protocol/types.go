@@ -14,6 +14,18 @@ const (
 	RemoveFileType          = 10
 	RequestChunksType       = 11
 	ChunkType               = 12
+	AnnounceType            = 13
+	PeerExchangeType        = 14
+	HaveType                = 15
+	SubscribeFileType       = 16
+	UnsubscribeFileType     = 17
+	NodeUpdateType          = 18
+	UpdateChunksDeltaType   = 19
+	ChokeType               = 20
+	UnchokeType             = 21
+	RejectType              = 22
+	CancelType              = 23
+	InvalidPublishType      = 24
 )
 
 type Packet interface {
@@ -48,6 +60,30 @@ func PacketStructFromType(packetType uint8) Packet {
 		return &RequestChunksPacket{}
 	case ChunkType:
 		return &ChunkPacket{}
+	case AnnounceType:
+		return &AnnouncePacket{}
+	case PeerExchangeType:
+		return &PeerExchangePacket{}
+	case HaveType:
+		return &HavePacket{}
+	case SubscribeFileType:
+		return &SubscribeFilePacket{}
+	case UnsubscribeFileType:
+		return &UnsubscribeFilePacket{}
+	case NodeUpdateType:
+		return &NodeUpdatePacket{}
+	case UpdateChunksDeltaType:
+		return &UpdateChunksDeltaPacket{}
+	case ChokeType:
+		return &ChokePacket{}
+	case UnchokeType:
+		return &UnchokePacket{}
+	case RejectType:
+		return &RejectPacket{}
+	case CancelType:
+		return &CancelPacket{}
+	case InvalidPublishType:
+		return &InvalidPublishPacket{}
 	default:
 		return nil
 	}
@@ -0,0 +1,239 @@
+package ratelimit
+
+import (
+	"PessiTorrent/internal/structures"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	Unlimited = 0
+)
+
+// Limiter is a token bucket, refilled at a rate of bytes per second, which holds up to a second worth of bytes
+type Limiter struct {
+	rate   float64
+	tokens float64 // Negative when more than a whole bucket was taken at once
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func NewLimiter(rate uint64) *Limiter {
+	return &Limiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+func (l *Limiter) Rate() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return uint64(l.rate)
+}
+
+func (l *Limiter) SetRate(rate uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	l.rate = float64(rate)
+	l.tokens = math.Min(l.tokens, l.rate)
+}
+
+// Blocks until the given number of bytes can be sent. More than a whole bucket
+// is let through once the bucket is full, and paid back before anything else goes through
+func (l *Limiter) Wait(bytes int) {
+	for {
+		l.mu.Lock()
+		if l.rate == Unlimited {
+			l.mu.Unlock()
+			return
+		}
+
+		l.refill()
+		needed := math.Min(float64(bytes), l.rate)
+		if l.tokens >= needed {
+			l.tokens -= float64(bytes)
+			l.mu.Unlock()
+			return
+		}
+
+		wait := time.Duration((needed - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		time.Sleep(wait)
+	}
+}
+
+// Returns how many units of the given size are available, up to max, without taking them.
+// Like in Wait, a full bucket always has room for a unit, even if it is larger than the bucket
+func (l *Limiter) Available(max int, unit int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == Unlimited {
+		return max
+	}
+
+	l.refill()
+	if l.tokens < math.Min(float64(unit), l.rate) {
+		return 0
+	}
+
+	return int(math.Min(float64(max), math.Max(1, math.Floor(l.tokens/float64(unit)))))
+}
+
+func (l *Limiter) Take(bytes int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == Unlimited {
+		return
+	}
+
+	l.refill()
+	l.tokens -= float64(bytes)
+}
+
+// Must be called with the lock held
+func (l *Limiter) refill() {
+	now := time.Now()
+	l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+}
+
+// Limits combines a global limit with a limit for each peer and for each file.
+// Every peer and file has the same default limit, unless it is overridden
+type Limits struct {
+	global *Limiter
+
+	peerRate uint64
+	fileRate uint64
+	peers    structures.SynchronizedMap[string, *Limiter]
+	files    structures.SynchronizedMap[string, *Limiter]
+	// File name -> Limit that overrides the default one
+	fileRates structures.SynchronizedMap[string, uint64]
+}
+
+func NewLimits(globalRate uint64, peerRate uint64, fileRate uint64) *Limits {
+	return &Limits{
+		global:    NewLimiter(globalRate),
+		peerRate:  peerRate,
+		fileRate:  fileRate,
+		peers:     structures.NewSynchronizedMap[string, *Limiter](),
+		files:     structures.NewSynchronizedMap[string, *Limiter](),
+		fileRates: structures.NewSynchronizedMap[string, uint64](),
+	}
+}
+
+// Blocks until the given number of bytes can be sent to the peer, as part of the file
+func (l *Limits) Wait(peer string, file string, bytes int) {
+	for _, limiter := range l.limiters(peer, file) {
+		limiter.Wait(bytes)
+	}
+}
+
+// Returns how many units of the given size every limit allows right now, up to max
+func (l *Limits) Available(peer string, file string, max int, unit int) int {
+	available := max
+	for _, limiter := range l.limiters(peer, file) {
+		available = int(math.Min(float64(available), float64(limiter.Available(max, unit))))
+	}
+
+	return available
+}
+
+// Accounts for bytes that are about to be transferred, without waiting
+func (l *Limits) Take(peer string, file string, bytes int) {
+	for _, limiter := range l.limiters(peer, file) {
+		limiter.Take(bytes)
+	}
+}
+
+func (l *Limits) limiters(peer string, file string) []*Limiter {
+	l.peers.Lock()
+	peerLimiter, ok := l.peers.M[peer]
+	if !ok {
+		peerLimiter = NewLimiter(l.peerRate)
+		l.peers.M[peer] = peerLimiter
+	}
+	l.peers.Unlock()
+
+	l.files.Lock()
+	fileLimiter, ok := l.files.M[file]
+	if !ok {
+		rate, overridden := l.fileRates.Get(file)
+		if !overridden {
+			rate = l.fileRate
+		}
+		fileLimiter = NewLimiter(rate)
+		l.files.M[file] = fileLimiter
+	}
+	l.files.Unlock()
+
+	return []*Limiter{l.global, peerLimiter, fileLimiter}
+}
+
+func (l *Limits) GlobalRate() uint64 {
+	return l.global.Rate()
+}
+
+func (l *Limits) SetGlobalRate(rate uint64) {
+	l.global.SetRate(rate)
+}
+
+func (l *Limits) PeerRate() uint64 {
+	l.peers.Lock()
+	defer l.peers.Unlock()
+
+	return l.peerRate
+}
+
+// Changes the limit of every peer
+func (l *Limits) SetPeerRate(rate uint64) {
+	l.peers.Lock()
+	defer l.peers.Unlock()
+
+	l.peerRate = rate
+	for _, limiter := range l.peers.M {
+		limiter.SetRate(rate)
+	}
+}
+
+func (l *Limits) FileRate(file string) uint64 {
+	if rate, ok := l.fileRates.Get(file); ok {
+		return rate
+	}
+
+	l.files.Lock()
+	defer l.files.Unlock()
+
+	return l.fileRate
+}
+
+// Changes the limit of a single file, overriding the default one
+func (l *Limits) SetFileRate(file string, rate uint64) {
+	l.files.Lock()
+	defer l.files.Unlock()
+
+	l.fileRates.Put(file, rate)
+	if limiter, ok := l.files.M[file]; ok {
+		limiter.SetRate(rate)
+	}
+}
+
+// Changes the limit of every file whose limit was not overridden
+func (l *Limits) SetDefaultFileRate(rate uint64) {
+	l.files.Lock()
+	defer l.files.Unlock()
+
+	l.fileRate = rate
+	for file, limiter := range l.files.M {
+		if !l.fileRates.Contains(file) {
+			limiter.SetRate(rate)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterWait(t *testing.T) {
+	limiter := NewLimiter(1000)
+
+	// The bucket starts full, so only the second second worth of bytes waits
+	start := time.Now()
+	limiter.Wait(1000)
+	limiter.Wait(200)
+	elapsed := time.Since(start)
+
+	if elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to wait about 200ms, waited %s", elapsed)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	limiter := NewLimiter(Unlimited)
+
+	start := time.Now()
+	limiter.Wait(1 << 30)
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expected unlimited limiter not to wait")
+	}
+
+	if available := limiter.Available(10, 1<<20); available != 10 {
+		t.Errorf("Expected 10 units available, got %d", available)
+	}
+}
+
+func TestLimitsAvailable(t *testing.T) {
+	limits := NewLimits(Unlimited, 3000, Unlimited)
+	limits.SetFileRate("file", 2000)
+
+	// The file limit is the strictest
+	if available := limits.Available("peer", "file", 10, 1000); available != 2 {
+		t.Errorf("Expected 2 units to be available, got %d", available)
+	}
+	limits.Take("peer", "file", 2000)
+
+	// The peer limit only has one unit left
+	if available := limits.Available("peer", "other", 10, 1000); available != 1 {
+		t.Errorf("Expected 1 unit to be available, got %d", available)
+	}
+
+	if available := limits.Available("other", "file", 10, 1000); available != 0 {
+		t.Errorf("Expected no units to be available, got %d", available)
+	}
+}
+
+func TestLimiterUnitLargerThanBucket(t *testing.T) {
+	limiter := NewLimiter(100)
+
+	if available := limiter.Available(10, 1000); available != 1 {
+		t.Errorf("Expected a full bucket to allow one unit, got %d", available)
+	}
+
+	limiter.Take(1000)
+	if available := limiter.Available(10, 1000); available != 0 {
+		t.Errorf("Expected no units to be available while paying back, got %d", available)
+	}
+}
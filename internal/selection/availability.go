@@ -0,0 +1,57 @@
+package selection
+
+import "sync"
+
+// Availability counts how many nodes have each chunk of a file. It is kept up to date as nodes gain
+// and lose chunks, so chunks can be ordered by rarity without going through every node
+type Availability struct {
+	counts map[uint16]int
+	mu     sync.Mutex
+}
+
+func NewAvailability() *Availability {
+	return &Availability{
+		counts: make(map[uint16]int),
+	}
+}
+
+// Records that one more node has the chunk
+func (a *Availability) Add(chunk uint16) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.counts[chunk]++
+}
+
+// Records that one less node has the chunk
+func (a *Availability) Remove(chunk uint16) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.counts[chunk] <= 1 {
+		delete(a.counts, chunk)
+		return
+	}
+	a.counts[chunk]--
+}
+
+// Returns how many nodes have the chunk
+func (a *Availability) Count(chunk uint16) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.counts[chunk]
+}
+
+// Returns how many nodes have each of the given chunks, in the same order
+func (a *Availability) Counts(chunks []uint16) []int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	counts := make([]int, len(chunks))
+	for i, chunk := range chunks {
+		counts[i] = a.counts[chunk]
+	}
+
+	return counts
+}
@@ -0,0 +1,224 @@
+package selection
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	RandomFirstChunks = 4  // Chunks downloaded at random before switching to rarest first
+	StreamingWindow   = 32 // Chunks after the playback cursor requested in order before any other
+)
+
+// PieceSelector decides the order the missing chunks of a file are requested in
+type PieceSelector interface {
+	// Sorts the missing chunks in the order they should be requested in, given how many were already downloaded
+	Order(missing []uint16, downloaded int)
+}
+
+// Strategies a download can select its chunks with
+const (
+	RarestFirstStrategy = 0
+	SequentialStrategy  = 1
+	RandomFirstStrategy = 2
+	PriorityStrategy    = 3
+	StreamingStrategy   = 4
+)
+
+var strategyNames = map[uint8]string{
+	RarestFirstStrategy: "rarest-first",
+	SequentialStrategy:  "sequential",
+	RandomFirstStrategy: "random-first",
+	PriorityStrategy:    "priority",
+	StreamingStrategy:   "streaming",
+}
+
+func StrategyName(strategy uint8) string {
+	return strategyNames[strategy]
+}
+
+func ParseStrategy(name string) (uint8, error) {
+	for strategy, strategyName := range strategyNames {
+		if strings.EqualFold(name, strategyName) {
+			return strategy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown selection strategy: %s", name)
+}
+
+// Returns the selector of the given strategy, which orders chunks by the given availability if it needs to
+func New(strategy uint8, availability *Availability) PieceSelector {
+	switch strategy {
+	case SequentialStrategy:
+		return NewSequential()
+	case RandomFirstStrategy:
+		return NewRandomFirst(availability)
+	case PriorityStrategy:
+		return NewPriority(NewRarestFirst(availability))
+	case StreamingStrategy:
+		return NewStreaming(availability)
+	default:
+		return NewRarestFirst(availability)
+	}
+}
+
+// RarestFirst requests the chunks the fewest nodes have first, so they are spread before those nodes leave.
+// Chunks as rare as each other are requested in random order, so downloaders do not all ask for the same one
+type RarestFirst struct {
+	availability *Availability
+}
+
+func NewRarestFirst(availability *Availability) *RarestFirst {
+	return &RarestFirst{
+		availability: availability,
+	}
+}
+
+func (r *RarestFirst) Order(missing []uint16, _ int) {
+	rand.Shuffle(len(missing), func(i, j int) {
+		missing[i], missing[j] = missing[j], missing[i]
+	})
+
+	counts := r.availability.Counts(missing)
+	sort.Stable(byCount{missing, counts})
+}
+
+// Sorts chunks along with how many nodes have them
+type byCount struct {
+	chunks []uint16
+	counts []int
+}
+
+func (b byCount) Len() int {
+	return len(b.chunks)
+}
+
+func (b byCount) Less(i, j int) bool {
+	return b.counts[i] < b.counts[j]
+}
+
+func (b byCount) Swap(i, j int) {
+	b.chunks[i], b.chunks[j] = b.chunks[j], b.chunks[i]
+	b.counts[i], b.counts[j] = b.counts[j], b.counts[i]
+}
+
+// Sequential requests chunks from the start of the file to its end
+type Sequential struct{}
+
+func NewSequential() *Sequential {
+	return &Sequential{}
+}
+
+func (s *Sequential) Order(missing []uint16, _ int) {
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i] < missing[j]
+	})
+}
+
+// RandomFirst requests random chunks until a few were downloaded, and then the rarest first.
+// Rare chunks are slow to get, while a new node needs some chunk soon to have something to upload
+type RandomFirst struct {
+	rarestFirst *RarestFirst
+}
+
+func NewRandomFirst(availability *Availability) *RandomFirst {
+	return &RandomFirst{
+		rarestFirst: NewRarestFirst(availability),
+	}
+}
+
+func (r *RandomFirst) Order(missing []uint16, downloaded int) {
+	if downloaded >= RandomFirstChunks {
+		r.rarestFirst.Order(missing, downloaded)
+		return
+	}
+
+	rand.Shuffle(len(missing), func(i, j int) {
+		missing[i], missing[j] = missing[j], missing[i]
+	})
+}
+
+// Priority requests the chunks with the highest priority first, and those of the same priority in the order of another selector.
+// Chunks have priority zero unless set otherwise
+type Priority struct {
+	priorities map[uint16]int
+	fallback   PieceSelector
+	mu         sync.Mutex
+}
+
+func NewPriority(fallback PieceSelector) *Priority {
+	return &Priority{
+		priorities: make(map[uint16]int),
+		fallback:   fallback,
+	}
+}
+
+// Sets the priority of the chunks from first to last, both included
+func (p *Priority) SetPriority(first uint16, last uint16, priority int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for chunk := int(first); chunk <= int(last); chunk++ {
+		if priority == 0 {
+			delete(p.priorities, uint16(chunk))
+		} else {
+			p.priorities[uint16(chunk)] = priority
+		}
+	}
+}
+
+func (p *Priority) Order(missing []uint16, downloaded int) {
+	p.fallback.Order(missing, downloaded)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sort.SliceStable(missing, func(i, j int) bool {
+		return p.priorities[missing[i]] > p.priorities[missing[j]]
+	})
+}
+
+// Streaming requests the chunks right after a playback cursor in order, so they are there by the time they are played,
+// and the rest of the file rarest first. The cursor follows whatever is being read from the file
+type Streaming struct {
+	cursor      atomic.Uint32
+	rarestFirst *RarestFirst
+}
+
+func NewStreaming(availability *Availability) *Streaming {
+	return &Streaming{
+		rarestFirst: NewRarestFirst(availability),
+	}
+}
+
+// Moves the cursor to the given chunk
+func (s *Streaming) SetCursor(chunk uint16) {
+	s.cursor.Store(uint32(chunk))
+}
+
+func (s *Streaming) Cursor() uint16 {
+	return uint16(s.cursor.Load())
+}
+
+func (s *Streaming) Order(missing []uint16, downloaded int) {
+	s.rarestFirst.Order(missing, downloaded)
+
+	cursor := int(s.Cursor())
+	inWindow := func(chunk uint16) bool {
+		return int(chunk) >= cursor && int(chunk) < cursor+StreamingWindow
+	}
+
+	sort.SliceStable(missing, func(i, j int) bool {
+		windowI, windowJ := inWindow(missing[i]), inWindow(missing[j])
+		if windowI && windowJ {
+			return missing[i] < missing[j]
+		}
+
+		return windowI && !windowJ
+	})
+}
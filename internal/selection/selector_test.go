@@ -0,0 +1,105 @@
+package selection
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAvailability(t *testing.T) {
+	availability := NewAvailability()
+	availability.Add(1)
+	availability.Add(1)
+	availability.Add(2)
+	availability.Remove(1)
+	availability.Remove(3)
+
+	counts := availability.Counts([]uint16{1, 2, 3})
+	if !slices.Equal(counts, []int{1, 1, 0}) {
+		t.Errorf("Expected counts [1 1 0], got %v", counts)
+	}
+}
+
+func TestRarestFirst(t *testing.T) {
+	availability := NewAvailability()
+	for chunk, count := range []int{3, 1, 2, 0} {
+		for i := 0; i < count; i++ {
+			availability.Add(uint16(chunk))
+		}
+	}
+
+	missing := []uint16{0, 1, 2, 3}
+	NewRarestFirst(availability).Order(missing, 0)
+
+	if !slices.Equal(missing, []uint16{3, 1, 2, 0}) {
+		t.Errorf("Expected [3 1 2 0], got %v", missing)
+	}
+}
+
+func TestSequential(t *testing.T) {
+	missing := []uint16{5, 2, 9, 0}
+	NewSequential().Order(missing, 0)
+
+	if !slices.Equal(missing, []uint16{0, 2, 5, 9}) {
+		t.Errorf("Expected [0 2 5 9], got %v", missing)
+	}
+}
+
+func TestRandomFirstSwitchesToRarest(t *testing.T) {
+	availability := NewAvailability()
+	availability.Add(0)
+	availability.Add(0)
+	availability.Add(1)
+
+	selector := NewRandomFirst(availability)
+
+	missing := []uint16{0, 1, 2}
+	selector.Order(missing, 0)
+	sorted := slices.Clone(missing)
+	slices.Sort(sorted)
+	if !slices.Equal(sorted, []uint16{0, 1, 2}) {
+		t.Errorf("Expected a permutation of [0 1 2], got %v", missing)
+	}
+
+	selector.Order(missing, RandomFirstChunks)
+	if !slices.Equal(missing, []uint16{2, 1, 0}) {
+		t.Errorf("Expected [2 1 0], got %v", missing)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	selector := NewPriority(NewSequential())
+	selector.SetPriority(4, 5, 2)
+	selector.SetPriority(1, 1, 1)
+
+	missing := []uint16{0, 1, 2, 3, 4, 5}
+	selector.Order(missing, 0)
+
+	if !slices.Equal(missing, []uint16{4, 5, 1, 0, 2, 3}) {
+		t.Errorf("Expected [4 5 1 0 2 3], got %v", missing)
+	}
+}
+
+func TestStreamingFollowsCursor(t *testing.T) {
+	selector := NewStreaming(NewAvailability())
+	selector.SetCursor(40)
+
+	missing := []uint16{0, 75, 41, 40, 71, 10}
+	selector.Order(missing, 0)
+
+	if !slices.Equal(missing[:3], []uint16{40, 41, 71}) {
+		t.Errorf("Expected [40 41 71] first, got %v", missing)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for strategy, name := range strategyNames {
+		parsed, err := ParseStrategy(name)
+		if err != nil || parsed != strategy {
+			t.Errorf("Expected %s to parse to %d, got %d (%v)", name, strategy, parsed, err)
+		}
+	}
+
+	if _, err := ParseStrategy("fastest"); err == nil {
+		t.Errorf("Expected an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,479 @@
+package storage
+
+import (
+	"PessiTorrent/internal/protocol"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ManifestFile = "manifest" // Inside the storage directory, next to the chunks
+)
+
+// ContentAddressedStorage keeps every distinct chunk once, in a file named after its hash,
+// so identical chunks of different files are only stored once.
+// Which chunks make up each file is journaled to the manifest file, so files survive a restart.
+// They are only reachable through the storage, never as regular files at their path
+type ContentAddressedStorage struct {
+	directory string
+	journal   *os.File // Every change to the manifests is appended to it
+
+	files      map[string]*manifest
+	references map[string]int // Chunk hash -> Number of file chunks with that content
+	replaying  bool           // While the journal is replayed, no chunk is deleted
+	mu         sync.Mutex
+}
+
+type manifest struct {
+	size    uint64
+	chunks  map[int64]chunk // Offset -> Chunk written at that offset
+	offsets []int64         // Offsets of the chunks, sorted
+}
+
+type chunk struct {
+	hash   string
+	length int64
+}
+
+func newManifest(size uint64) *manifest {
+	return &manifest{size: size, chunks: make(map[int64]chunk)}
+}
+
+// Returns the offset of the chunk holding the given offset, if any was written there
+func (m *manifest) find(offset int64) (int64, chunk, bool) {
+	i := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] > offset })
+	if i == 0 {
+		return 0, chunk{}, false
+	}
+
+	start := m.offsets[i-1]
+	written := m.chunks[start]
+	return start, written, offset < start+written.length
+}
+
+// Returns the offset of the first chunk written after the given offset, or -1 if there is none
+func (m *manifest) next(offset int64) int64 {
+	i := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] > offset })
+	if i == len(m.offsets) {
+		return -1
+	}
+
+	return m.offsets[i]
+}
+
+func (m *manifest) put(offset int64, written chunk) {
+	if _, ok := m.chunks[offset]; !ok {
+		i := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] >= offset })
+		m.offsets = append(m.offsets, 0)
+		copy(m.offsets[i+1:], m.offsets[i:])
+		m.offsets[i] = offset
+	}
+
+	m.chunks[offset] = written
+}
+
+func (m *manifest) delete(offset int64) {
+	delete(m.chunks, offset)
+
+	i := sort.Search(len(m.offsets), func(i int) bool { return m.offsets[i] >= offset })
+	if i < len(m.offsets) && m.offsets[i] == offset {
+		m.offsets = append(m.offsets[:i], m.offsets[i+1:]...)
+	}
+}
+
+// Operations journaled to the manifest file
+const (
+	createOperation = 0
+	writeOperation  = 1
+	renameOperation = 2
+	removeOperation = 3
+)
+
+type journalRecord struct {
+	Operation uint8
+	Path      string
+	NewPath   string // Only for renames
+	Offset    int64  // Only for writes
+	Size      uint64 // Size of the file for creates, length of the chunk for writes
+	Hash      string // Only for writes
+}
+
+func NewContentAddressedStorage(directory string) (*ContentAddressedStorage, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ContentAddressedStorage{
+		directory:  directory,
+		files:      make(map[string]*manifest),
+		references: make(map[string]int),
+	}
+
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Rebuilds the manifests and the reference counts from the journal, deletes the chunks no file uses,
+// and compacts the journal to a single record per file chunk
+func (s *ContentAddressedStorage) load() error {
+	journalPath := filepath.Join(s.directory, ManifestFile)
+
+	file, err := os.Open(journalPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		s.replaying = true
+		reader := bufio.NewReader(file)
+		for {
+			var record journalRecord
+			// A record cut short by a crash is where the journal ends
+			if err := protocol.DeserializeToStruct(reader, &record); err != nil {
+				break
+			}
+			s.apply(record)
+		}
+		s.replaying = false
+		_ = file.Close()
+	}
+
+	s.deleteUnusedChunks()
+
+	buffer := new(bytes.Buffer)
+	for path, file := range s.files {
+		err := protocol.SerializeStruct(buffer, &journalRecord{Operation: createOperation, Path: path, Size: file.size})
+		if err != nil {
+			return err
+		}
+
+		for _, offset := range file.offsets {
+			written := file.chunks[offset]
+			err := protocol.SerializeStruct(buffer, &journalRecord{Operation: writeOperation, Path: path, Offset: offset, Size: uint64(written.length), Hash: written.hash})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = os.WriteFile(journalPath+".tmp", buffer.Bytes(), Permissions)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(journalPath+".tmp", journalPath)
+	if err != nil {
+		return err
+	}
+
+	s.journal, err = os.OpenFile(journalPath, os.O_WRONLY|os.O_APPEND, Permissions)
+	return err
+}
+
+// Deletes the chunks left behind by a crash before they were journaled, or after they were released
+func (s *ContentAddressedStorage) deleteUnusedChunks() {
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		hash := strings.TrimSuffix(name, ".tmp")
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha256.Size {
+			continue // Not a chunk
+		}
+
+		if name != hash || s.references[hash] == 0 {
+			_ = os.Remove(filepath.Join(s.directory, name))
+		}
+	}
+}
+
+// Appends the record to the journal and applies it to the manifests. Must be called with the lock held
+func (s *ContentAddressedStorage) record(record journalRecord) error {
+	buffer := new(bytes.Buffer)
+	err := protocol.SerializeStruct(buffer, &record)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.journal.Write(buffer.Bytes())
+	if err != nil {
+		return fmt.Errorf("error journaling manifest: %w", err)
+	}
+
+	s.apply(record)
+	return nil
+}
+
+// Must be called with the lock held
+func (s *ContentAddressedStorage) apply(record journalRecord) {
+	switch record.Operation {
+	case createOperation:
+		file, ok := s.files[record.Path]
+		if !ok {
+			s.files[record.Path] = newManifest(record.Size)
+			return
+		}
+
+		file.size = record.Size
+		for _, offset := range append([]int64(nil), file.offsets...) {
+			if uint64(offset) >= record.Size {
+				s.release(file.chunks[offset].hash)
+				file.delete(offset)
+			}
+		}
+
+	case writeOperation:
+		file, ok := s.files[record.Path]
+		if !ok {
+			file = newManifest(0)
+			s.files[record.Path] = file
+		}
+
+		s.references[record.Hash]++
+
+		if previous, ok := file.chunks[record.Offset]; ok {
+			s.release(previous.hash)
+		}
+		file.put(record.Offset, chunk{hash: record.Hash, length: int64(record.Size)})
+
+		if end := uint64(record.Offset) + record.Size; end > file.size {
+			file.size = end
+		}
+
+	case renameOperation:
+		file, ok := s.files[record.Path]
+		if !ok {
+			return
+		}
+
+		if replaced, ok := s.files[record.NewPath]; ok {
+			s.releaseAll(replaced)
+		}
+
+		delete(s.files, record.Path)
+		s.files[record.NewPath] = file
+
+	case removeOperation:
+		if file, ok := s.files[record.Path]; ok {
+			s.releaseAll(file)
+			delete(s.files, record.Path)
+		}
+	}
+}
+
+func (s *ContentAddressedStorage) Create(path string, size uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.record(journalRecord{Operation: createOperation, Path: path, Size: size})
+}
+
+// Chunks are written whole, so a write may replace the chunk at the same offset but not overlap any other
+func (s *ContentAddressedStorage) WriteAt(path string, data []byte, offset int64) error {
+	digest := sha256.Sum256(data)
+	hash := hex.EncodeToString(digest[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file, ok := s.files[path]; ok {
+		if start, _, ok := file.find(offset); ok && start != offset {
+			return fmt.Errorf("write at offset %d overlaps the chunk at offset %d", offset, start)
+		}
+		if next := file.next(offset); next != -1 && next < offset+int64(len(data)) {
+			return fmt.Errorf("write at offset %d overlaps the chunk at offset %d", offset, next)
+		}
+	}
+
+	if s.references[hash] == 0 {
+		err := s.writeChunk(hash, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.record(journalRecord{Operation: writeOperation, Path: path, Offset: offset, Size: uint64(len(data)), Hash: hash})
+	if err != nil && s.references[hash] == 0 {
+		_ = os.Remove(filepath.Join(s.directory, hash))
+	}
+
+	return err
+}
+
+func (s *ContentAddressedStorage) writeChunk(hash string, data []byte) error {
+	chunkPath := filepath.Join(s.directory, hash)
+
+	err := os.WriteFile(chunkPath+".tmp", data, Permissions)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(chunkPath+".tmp", chunkPath)
+}
+
+// Reads from inside the chunks covering the offset, so it does not need to be where a chunk starts.
+// Parts never written are read as zeros, like the holes of a sparse file
+func (s *ContentAddressedStorage) ReadAt(path string, buffer []byte, offset int64) (int, error) {
+	s.mu.Lock()
+	file, ok := s.files[path]
+	if !ok {
+		s.mu.Unlock()
+		return 0, ErrNotExist
+	}
+
+	if uint64(offset) >= file.size {
+		s.mu.Unlock()
+		return 0, nil
+	}
+
+	length := int64(len(buffer))
+	if remaining := int64(file.size) - offset; remaining < length {
+		length = remaining
+	}
+
+	// Chunks are read once the lock is released
+	segments := make([]segment, 0)
+	for read := int64(0); read < length; {
+		position := offset + read
+
+		start, written, ok := file.find(position)
+		if !ok {
+			// Zeros up to the next chunk written
+			end := offset + length
+			if next := file.next(position); next != -1 && next < end {
+				end = next
+			}
+			clear(buffer[read : end-offset])
+			read = end - offset
+			continue
+		}
+
+		end := min(start+written.length, offset+length)
+		segments = append(segments, segment{hash: written.hash, from: read, to: end - offset, offset: position - start})
+		read = end - offset
+	}
+	s.mu.Unlock()
+
+	for _, segment := range segments {
+		err := s.readChunk(segment.hash, buffer[segment.from:segment.to], segment.offset)
+		if err != nil {
+			return int(segment.from), err
+		}
+	}
+
+	return int(length), nil
+}
+
+// Part of a read served by a chunk
+type segment struct {
+	hash     string
+	from, to int64 // Where in the buffer
+	offset   int64 // Where in the chunk
+}
+
+func (s *ContentAddressedStorage) readChunk(hash string, buffer []byte, offset int64) error {
+	chunkFile, err := os.Open(filepath.Join(s.directory, hash))
+	if err != nil {
+		return err
+	}
+	defer chunkFile.Close()
+
+	_, err = chunkFile.ReadAt(buffer, offset)
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("chunk %s is shorter than its manifest says: %w", hash, io.ErrUnexpectedEOF)
+	}
+
+	return err
+}
+
+func (s *ContentAddressedStorage) Size(path string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, ok := s.files[path]
+	if !ok {
+		return 0, ErrNotExist
+	}
+
+	return file.size, nil
+}
+
+func (s *ContentAddressedStorage) Exists(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.files[path]
+	return ok
+}
+
+func (s *ContentAddressedStorage) Rename(oldPath string, newPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[oldPath]; !ok {
+		return ErrNotExist
+	}
+
+	return s.record(journalRecord{Operation: renameOperation, Path: oldPath, NewPath: newPath})
+}
+
+func (s *ContentAddressedStorage) Remove(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.files[path]; !ok {
+		return nil
+	}
+
+	return s.record(journalRecord{Operation: removeOperation, Path: path})
+}
+
+func (s *ContentAddressedStorage) Close(_ string) {}
+
+// Returns the number of distinct chunks stored
+func (s *ContentAddressedStorage) NumberOfChunks() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.references)
+}
+
+// Must be called with the lock held
+func (s *ContentAddressedStorage) releaseAll(file *manifest) {
+	for _, written := range file.chunks {
+		s.release(written.hash)
+	}
+}
+
+// Deletes the chunk once no file uses it anymore. Must be called with the lock held
+func (s *ContentAddressedStorage) release(hash string) {
+	s.references[hash]--
+	if s.references[hash] > 0 {
+		return
+	}
+
+	delete(s.references, hash)
+
+	// While replaying, a later record may use the chunk again. Unused chunks are deleted once the journal is replayed
+	if !s.replaying {
+		_ = os.Remove(filepath.Join(s.directory, hash))
+	}
+}
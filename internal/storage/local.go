@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"PessiTorrent/internal/filereader"
+	"PessiTorrent/internal/structures"
+	"errors"
+	"os"
+)
+
+// LocalStorage keeps files as regular files on the local filesystem
+type LocalStorage struct {
+	readers *filereader.Pool
+	writers structures.SynchronizedMap[string, *os.File]
+}
+
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{
+		readers: filereader.NewPool(filereader.DefaultMaxHandles),
+		writers: structures.NewSynchronizedMap[string, *os.File](),
+	}
+}
+
+func (s *LocalStorage) Create(path string, size uint64) error {
+	file, err := s.writer(path)
+	if err != nil {
+		return err
+	}
+
+	// Sparse file, chunks are written in any order
+	return file.Truncate(int64(size))
+}
+
+func (s *LocalStorage) Preallocate(path string, size uint64) error {
+	file, err := s.writer(path)
+	if err != nil {
+		return err
+	}
+
+	return preallocate(file, size)
+}
+
+func (s *LocalStorage) WriteAt(path string, data []byte, offset int64) error {
+	file, err := s.writer(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteAt(data, offset)
+	return err
+}
+
+func (s *LocalStorage) writer(path string) (*os.File, error) {
+	s.writers.Lock()
+	defer s.writers.Unlock()
+
+	if file, ok := s.writers.M[path]; ok {
+		return file, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, Permissions)
+	if err != nil {
+		return nil, err
+	}
+	s.writers.M[path] = file
+
+	return file, nil
+}
+
+func (s *LocalStorage) ReadAt(path string, buffer []byte, offset int64) (int, error) {
+	read, err := s.readers.ReadAt(path, buffer, offset)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNotExist
+	}
+
+	return read, err
+}
+
+func (s *LocalStorage) Size(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNotExist
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(info.Size()), nil
+}
+
+func (s *LocalStorage) Exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, os.ErrNotExist)
+}
+
+func (s *LocalStorage) Rename(oldPath string, newPath string) error {
+	s.Close(oldPath)
+	s.Close(newPath)
+
+	return os.Rename(oldPath, newPath)
+}
+
+func (s *LocalStorage) Remove(path string) error {
+	s.Close(path)
+
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *LocalStorage) Close(path string) {
+	s.readers.Close(path)
+
+	s.writers.Lock()
+	file, ok := s.writers.M[path]
+	delete(s.writers.M, path)
+	s.writers.Unlock()
+
+	if ok {
+		_ = file.Close()
+	}
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"PessiTorrent/internal/structures"
+)
+
+// MemoryStorage keeps files in memory, which makes it useful for tests
+type MemoryStorage struct {
+	files structures.SynchronizedMap[string, []byte]
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: structures.NewSynchronizedMap[string, []byte](),
+	}
+}
+
+func (s *MemoryStorage) Create(path string, size uint64) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+
+	content := make([]byte, size)
+	copy(content, s.files.M[path])
+	s.files.M[path] = content
+
+	return nil
+}
+
+func (s *MemoryStorage) WriteAt(path string, data []byte, offset int64) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+
+	content := s.files.M[path]
+	if end := int(offset) + len(data); end > len(content) {
+		content = append(content, make([]byte, end-len(content))...)
+	}
+	copy(content[offset:], data)
+	s.files.M[path] = content
+
+	return nil
+}
+
+func (s *MemoryStorage) ReadAt(path string, buffer []byte, offset int64) (int, error) {
+	s.files.Lock()
+	defer s.files.Unlock()
+
+	content, ok := s.files.M[path]
+	if !ok {
+		return 0, ErrNotExist
+	}
+
+	if offset >= int64(len(content)) {
+		return 0, nil
+	}
+
+	return copy(buffer, content[offset:]), nil
+}
+
+func (s *MemoryStorage) Size(path string) (uint64, error) {
+	content, ok := s.files.Get(path)
+	if !ok {
+		return 0, ErrNotExist
+	}
+
+	return uint64(len(content)), nil
+}
+
+func (s *MemoryStorage) Exists(path string) bool {
+	return s.files.Contains(path)
+}
+
+func (s *MemoryStorage) Rename(oldPath string, newPath string) error {
+	s.files.Lock()
+	defer s.files.Unlock()
+
+	content, ok := s.files.M[oldPath]
+	if !ok {
+		return ErrNotExist
+	}
+
+	delete(s.files.M, oldPath)
+	s.files.M[newPath] = content
+
+	return nil
+}
+
+func (s *MemoryStorage) Remove(path string) error {
+	s.files.Delete(path)
+	return nil
+}
+
+func (s *MemoryStorage) Close(_ string) {}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// Reserves the blocks of the whole file, keeping whatever was already written to it
+func preallocate(file *os.File, size uint64) error {
+	return syscall.Fallocate(int(file.Fd()), 0, 0, int64(size))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// Reserves the blocks of the whole file, keeping whatever was already written to it
+func preallocate(_ *os.File, _ uint64) error {
+	return errors.ErrUnsupported
+}
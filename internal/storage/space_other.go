@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package storage
+
+import "errors"
+
+// Returns the number of bytes that can still be written to the filesystem of the given directory
+func FreeSpace(_ string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// Returns the number of bytes of the filesystem taken by the file, which is less than its size if it is sparse
+func AllocatedSpace(_ string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
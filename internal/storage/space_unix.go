@@ -0,0 +1,27 @@
+//go:build linux || darwin
+
+package storage
+
+import "syscall"
+
+// Returns the number of bytes that can still be written to the filesystem of the given directory
+func FreeSpace(directory string) (uint64, error) {
+	var stats syscall.Statfs_t
+	err := syscall.Statfs(directory, &stats)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(stats.Bavail) * uint64(stats.Bsize), nil
+}
+
+// Returns the number of bytes of the filesystem taken by the file, which is less than its size if it is sparse
+func AllocatedSpace(path string) (uint64, error) {
+	var stats syscall.Stat_t
+	err := syscall.Stat(path, &stats)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(stats.Blocks) * 512, nil // Blocks are always of 512 bytes, whatever the block size of the filesystem
+}
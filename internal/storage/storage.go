@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	Permissions = 0666
+)
+
+var ErrNotExist = errors.New("file does not exist")
+
+// Storage is where the content of files is read from while seeding and written to while downloading.
+// Files are identified by their path, and are always read and written a whole chunk at a time
+type Storage interface {
+	// Prepares a file of the given size to be written to, keeping whatever content it already has
+	Create(path string, size uint64) error
+	WriteAt(path string, data []byte, offset int64) error
+	// Reads the chunk at the given offset. Reaching the end of the file is not an error,
+	// the number of bytes read tells how much of the buffer was filled
+	ReadAt(path string, buffer []byte, offset int64) (int, error)
+	Size(path string) (uint64, error)
+	Exists(path string) bool
+	Rename(oldPath string, newPath string) error
+	Remove(path string) error
+	// Releases whatever is held open for the file, which can still be used afterwards
+	Close(path string)
+}
+
+// Preallocator is implemented by storages that can reserve the space of a file before it is written to
+type Preallocator interface {
+	Preallocate(path string, size uint64) error
+}
+
+// Reserves the space of the file if the storage supports it, failing with errors.ErrUnsupported otherwise
+func Preallocate(storage Storage, path string, size uint64) error {
+	preallocator, ok := storage.(Preallocator)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	return preallocator.Preallocate(path, size)
+}
+
+// Backends the storage can be configured with
+const (
+	LocalBackend          = 0
+	ContentAddressBackend = 1
+)
+
+var backendNames = map[uint8]string{
+	LocalBackend:          "local",
+	ContentAddressBackend: "content-addressed",
+}
+
+func BackendName(backend uint8) string {
+	return backendNames[backend]
+}
+
+func ParseBackend(name string) (uint8, error) {
+	for backend, backendName := range backendNames {
+		if name == backendName {
+			return backend, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown storage backend: %s", name)
+}
+
+// Reader reads a file of a storage from start to end. Buffers given to it must be of the chunk size of the file
+type Reader struct {
+	storage Storage
+	path    string
+	offset  int64
+}
+
+func NewReader(storage Storage, path string) *Reader {
+	return &Reader{
+		storage: storage,
+		path:    path,
+	}
+}
+
+func (r *Reader) Read(buffer []byte) (int, error) {
+	read, err := r.storage.ReadAt(r.path, buffer, r.offset)
+	if err != nil {
+		return read, err
+	}
+
+	if read == 0 && len(buffer) > 0 {
+		return 0, io.EOF
+	}
+
+	r.offset += int64(read)
+	return read, nil
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorages(t *testing.T) {
+	directory := t.TempDir()
+
+	contentAddressed, err := NewContentAddressedStorage(filepath.Join(directory, "chunks"))
+	if err != nil {
+		t.Fatalf("Error creating content addressed storage: %v", err)
+	}
+
+	storages := map[string]Storage{
+		"local":             NewLocalStorage(),
+		"memory":            NewMemoryStorage(),
+		"content-addressed": contentAddressed,
+	}
+
+	for name, storage := range storages {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(directory, name)
+			testStorage(t, storage, path)
+		})
+	}
+}
+
+func testStorage(t *testing.T, storage Storage, path string) {
+	if storage.Exists(path) {
+		t.Fatalf("Expected %s not to exist", path)
+	}
+
+	if err := storage.Create(path, 10); err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+
+	// Chunks are written out of order
+	if err := storage.WriteAt(path, []byte("6789"), 6); err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+	if err := storage.WriteAt(path, []byte("012"), 0); err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+
+	if size, err := storage.Size(path); err != nil || size != 10 {
+		t.Errorf("Expected size 10, got %d (%v)", size, err)
+	}
+
+	buffer := make([]byte, 3)
+	if read, err := storage.ReadAt(path, buffer, 3); err != nil || !bytes.Equal(buffer[:read], []byte{0, 0, 0}) {
+		t.Errorf("Expected an unwritten chunk to be zeros, got %v (%v)", buffer[:read], err)
+	}
+
+	buffer = make([]byte, 6)
+	if read, err := storage.ReadAt(path, buffer, 6); err != nil || string(buffer[:read]) != "6789" {
+		t.Errorf("Expected to read the last chunk, got %q (%v)", buffer[:read], err)
+	}
+
+	// Reads do not need to start where a chunk does
+	buffer = make([]byte, 6)
+	if read, err := storage.ReadAt(path, buffer, 1); err != nil || !bytes.Equal(buffer[:read], []byte("12\x00\x00\x006")) {
+		t.Errorf("Expected to read across chunks from an unaligned offset, got %q (%v)", buffer[:read], err)
+	}
+	buffer = make([]byte, 2)
+	if read, err := storage.ReadAt(path, buffer, 7); err != nil || string(buffer[:read]) != "78" {
+		t.Errorf("Expected to read from inside the last chunk, got %q (%v)", buffer[:read], err)
+	}
+
+	newPath := path + ".renamed"
+	if err := storage.Rename(path, newPath); err != nil {
+		t.Fatalf("Error renaming file: %v", err)
+	}
+	if storage.Exists(path) || !storage.Exists(newPath) {
+		t.Errorf("Expected the file to be renamed")
+	}
+
+	buffer = make([]byte, 3)
+	if read, err := storage.ReadAt(newPath, buffer, 0); err != nil || string(buffer[:read]) != "012" {
+		t.Errorf("Expected to read the first chunk after renaming, got %q (%v)", buffer[:read], err)
+	}
+
+	if err := storage.Remove(newPath); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+	if _, err := storage.ReadAt(newPath, buffer, 0); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected removed file not to exist, got %v", err)
+	}
+}
+
+func TestContentAddressedDeduplication(t *testing.T) {
+	storage, err := NewContentAddressedStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Error creating content addressed storage: %v", err)
+	}
+
+	chunk := []byte("same content")
+	for _, path := range []string{"a", "b"} {
+		if err := storage.WriteAt(path, chunk, 0); err != nil {
+			t.Fatalf("Error writing chunk: %v", err)
+		}
+	}
+
+	if storage.NumberOfChunks() != 1 {
+		t.Errorf("Expected identical chunks to be stored once, got %d chunks", storage.NumberOfChunks())
+	}
+
+	// The chunk is still used by the other file
+	if err := storage.Remove("a"); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+
+	buffer := make([]byte, len(chunk))
+	if read, err := storage.ReadAt("b", buffer, 0); err != nil || !bytes.Equal(buffer[:read], chunk) {
+		t.Errorf("Expected chunk to still be readable, got %q (%v)", buffer[:read], err)
+	}
+
+	if err := storage.Remove("b"); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+
+	if storage.NumberOfChunks() != 0 {
+		t.Errorf("Expected unused chunks to be deleted, got %d chunks", storage.NumberOfChunks())
+	}
+}
+
+func TestContentAddressedSurvivesRestart(t *testing.T) {
+	directory := t.TempDir()
+
+	storage, err := NewContentAddressedStorage(directory)
+	if err != nil {
+		t.Fatalf("Error creating content addressed storage: %v", err)
+	}
+
+	if err := storage.Create("file", 8); err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	for offset, chunk := range []string{"0123", "4567"} {
+		if err := storage.WriteAt("file", []byte(chunk), int64(4*offset)); err != nil {
+			t.Fatalf("Error writing chunk: %v", err)
+		}
+	}
+	if err := storage.Rename("file", "renamed"); err != nil {
+		t.Fatalf("Error renaming file: %v", err)
+	}
+	if err := storage.WriteAt("removed", []byte("gone"), 0); err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+	if err := storage.Remove("removed"); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+
+	storage, err = NewContentAddressedStorage(directory)
+	if err != nil {
+		t.Fatalf("Error reopening content addressed storage: %v", err)
+	}
+
+	if storage.Exists("file") || storage.Exists("removed") {
+		t.Errorf("Expected renamed and removed files not to exist after reopening")
+	}
+
+	buffer := make([]byte, 8)
+	if read, err := storage.ReadAt("renamed", buffer, 0); err != nil || string(buffer[:read]) != "01234567" {
+		t.Errorf("Expected the file to be readable after reopening, got %q (%v)", buffer[:read], err)
+	}
+
+	if storage.NumberOfChunks() != 2 {
+		t.Errorf("Expected 2 chunks after reopening, got %d", storage.NumberOfChunks())
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+	storage := NewLocalStorage()
+	path := filepath.Join(t.TempDir(), "file")
+
+	if err := storage.WriteAt(path, []byte("012"), 0); err != nil {
+		t.Fatalf("Error writing chunk: %v", err)
+	}
+
+	err := Preallocate(storage, path, 1000)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("Preallocation is not supported on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Error preallocating file: %v", err)
+	}
+
+	// Content already written is kept
+	buffer := make([]byte, 3)
+	if read, err := storage.ReadAt(path, buffer, 0); err != nil || string(buffer[:read]) != "012" {
+		t.Errorf("Expected to read the first chunk after preallocating, got %q (%v)", buffer[:read], err)
+	}
+
+	if size, err := storage.Size(path); err != nil || size != 1000 {
+		t.Errorf("Expected size 1000, got %d (%v)", size, err)
+	}
+}
+
+func TestAllocatedSpace(t *testing.T) {
+	storage := NewLocalStorage()
+	path := filepath.Join(t.TempDir(), "file")
+
+	if err := storage.Create(path, 1<<20); err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+
+	sparse, err := AllocatedSpace(path)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("Allocated space is not known on this platform")
+	}
+	if err != nil {
+		t.Fatalf("Error getting allocated space: %v", err)
+	}
+
+	if err := Preallocate(storage, path, 1<<20); err != nil {
+		t.Skipf("Preallocation is not supported: %v", err)
+	}
+
+	// Preallocated blocks are taken even though nothing was written to them
+	if allocated, err := AllocatedSpace(path); err != nil || allocated < 1<<20 || allocated <= sparse {
+		t.Errorf("Expected at least %d bytes allocated after preallocating, got %d (%v)", 1<<20, allocated, err)
+	}
+}
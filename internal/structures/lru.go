@@ -0,0 +1,101 @@
+package structures
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRUCache keeps the most recently used values whose total cost fits in a capacity,
+// evicting the least recently used ones first
+type LRUCache[K comparable, V any] struct {
+	capacity int64
+	cost     func(val V) int64
+	used     int64
+
+	order   *list.List // Front is the most recently used
+	entries map[K]*list.Element
+	sync.Mutex
+}
+
+type lruEntry[K comparable, V any] struct {
+	key K
+	val V
+}
+
+func NewLRUCache[K comparable, V any](capacity int64, cost func(val V) int64) *LRUCache[K, V] {
+	return &LRUCache[K, V]{
+		capacity: capacity,
+		cost:     cost,
+		order:    list.New(),
+		entries:  make(map[K]*list.Element),
+	}
+}
+
+func (c *LRUCache[K, V]) Get(key K) (V, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).val, true
+}
+
+// Values that cost more than the whole capacity are not cached
+func (c *LRUCache[K, V]) Put(key K, val V) {
+	c.Lock()
+	defer c.Unlock()
+
+	cost := c.cost(val)
+	if cost > c.capacity {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key, val})
+	c.used += cost
+
+	for c.used > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *LRUCache[K, V]) Delete(key K) {
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+func (c *LRUCache[K, V]) DeleteWhere(predicate func(key K) bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, element := range c.entries {
+		if predicate(key) {
+			c.removeElement(element)
+		}
+	}
+}
+
+func (c *LRUCache[K, V]) Len() int {
+	c.Lock()
+	defer c.Unlock()
+
+	return len(c.entries)
+}
+
+func (c *LRUCache[K, V]) removeElement(element *list.Element) {
+	entry := c.order.Remove(element).(*lruEntry[K, V])
+	delete(c.entries, entry.key)
+	c.used -= c.cost(entry.val)
+}
@@ -0,0 +1,39 @@
+package structures
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache[string, []byte](10, func(val []byte) int64 {
+		return int64(len(val))
+	})
+
+	cache.Put("a", make([]byte, 4))
+	cache.Put("b", make([]byte, 4))
+
+	// Using "a" makes "b" the least recently used
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatalf("Expected a to be cached")
+	}
+
+	cache.Put("c", make([]byte, 4))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("Expected a to still be cached")
+	}
+	if _, ok := cache.Get("c"); !ok {
+		t.Errorf("Expected c to still be cached")
+	}
+
+	cache.Put("huge", make([]byte, 11))
+	if _, ok := cache.Get("huge"); ok {
+		t.Errorf("Expected values larger than the capacity not to be cached")
+	}
+
+	cache.DeleteWhere(func(key string) bool { return key == "a" })
+	if cache.Len() != 1 {
+		t.Errorf("Expected 1 cached value, got %d", cache.Len())
+	}
+}
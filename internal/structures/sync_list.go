@@ -118,3 +118,14 @@ func (l *SynchronizedList[V]) IndexesWhere(predicate func(V) bool) []uint {
 
 	return result
 }
+
+// Removes every element from the list, returning them
+func (l *SynchronizedList[V]) Drain() []V {
+	l.Lock()
+	defer l.Unlock()
+
+	result := l.L
+	l.L = make([]V, 0)
+
+	return result
+}
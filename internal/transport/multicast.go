@@ -0,0 +1,73 @@
+package transport
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"bytes"
+	"errors"
+	"net"
+)
+
+type MulticastServer struct {
+	listener     *net.UDPConn // Joined to the multicast group
+	sender       *net.UDPConn // Connected to the multicast group
+	readBuffer   []byte
+	handlePacket UDPPacketHandler
+	onClose      func()
+}
+
+func NewMulticastServer(listener *net.UDPConn, sender *net.UDPConn, handlePacket UDPPacketHandler, onClose func()) MulticastServer {
+	return MulticastServer{
+		listener,
+		sender,
+		make([]byte, UDPMaxPacketSize),
+		handlePacket,
+		onClose,
+	}
+}
+
+func (srv *MulticastServer) Start() {
+	go srv.readLoop()
+}
+
+func (srv *MulticastServer) Stop() {
+	srv.listener.Close()
+	srv.sender.Close()
+}
+
+func (srv *MulticastServer) readLoop() {
+	for {
+		n, addr, err := srv.listener.ReadFromUDP(srv.readBuffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				srv.onClose()
+				break
+			}
+			logger.Error("Error reading from multicast connection:", err)
+			continue
+		}
+
+		packet, err := protocol.DeserializePacket(bytes.NewReader(srv.readBuffer[:n]))
+		if err != nil {
+			logger.Error("Error deserializing packet:", err)
+			continue
+		}
+
+		go srv.handlePacket(packet, addr)
+	}
+}
+
+// Sends the packet to every node listening on the multicast group
+func (srv *MulticastServer) SendPacket(packet protocol.Packet) {
+	buffer := new(bytes.Buffer)
+	err := protocol.SerializePacket(buffer, packet)
+	if err != nil {
+		logger.Error("Error serializing packet:", err)
+		return
+	}
+
+	_, err = srv.sender.Write(buffer.Bytes())
+	if err != nil {
+		logger.Error("Error sending packet:", err)
+	}
+}
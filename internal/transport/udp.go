@@ -14,12 +14,16 @@ const (
 
 type UDPPacketHandler func(packet protocol.Packet, addr *net.UDPAddr)
 
+// Throttle is called before a packet of the given size is sent, and blocks for as long as it must be held back
+type Throttle func(packet protocol.Packet, addr *net.UDPAddr, size int)
+
 type UDPServer struct {
 	connection    net.UDPConn
 	readBuffer    []byte
 	requestsQueue chan RequestChunk
 	handlePacket  UDPPacketHandler
 	onClose       func()
+	throttle      Throttle
 }
 
 type RequestChunk struct {
@@ -34,9 +38,15 @@ func NewUDPServer(conn net.UDPConn, handlePacket UDPPacketHandler, onClose func(
 		make(chan RequestChunk),
 		handlePacket,
 		onClose,
+		nil,
 	}
 }
 
+// Must be set before the server is started
+func (srv *UDPServer) SetThrottle(throttle Throttle) {
+	srv.throttle = throttle
+}
+
 func (srv *UDPServer) Start() {
 	go srv.writeLoop()
 	go srv.readLoop()
@@ -60,6 +70,10 @@ func (srv *UDPServer) writeLoop() {
 			continue
 		}
 
+		if srv.throttle != nil {
+			srv.throttle(request.packet, request.addr, buffer.Len())
+		}
+
 		_, err = srv.connection.WriteToUDP(buffer.Bytes(), request.addr)
 		if err != nil {
 			logger.Error("Error sending packet:", err)
@@ -97,6 +111,10 @@ func (srv *UDPServer) SendPacket(packet protocol.Packet, addr *net.UDPAddr) {
 		return
 	}
 
+	if srv.throttle != nil {
+		srv.throttle(packet, addr, buffer.Len())
+	}
+
 	_, err = srv.connection.WriteToUDP(buffer.Bytes(), addr)
 	if err != nil {
 		logger.Error("Error sending packet:", err)
@@ -47,3 +47,19 @@ func StrToUDPAddr(addr string) ([4]byte, error) {
 
 	return UDPAddrToBytes(ip), nil
 }
+
+// Returns true if the given IP address belongs to one of the host's interfaces
+func IsLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
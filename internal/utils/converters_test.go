@@ -42,3 +42,13 @@ func TestStrToUDPPort(t *testing.T) {
 		t.Errorf("StrToUDPPort: expected %v, got %v", expected, result)
 	}
 }
+
+func TestIsLocalIP(t *testing.T) {
+	if !IsLocalIP(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("IsLocalIP: expected loopback address to be local")
+	}
+
+	if IsLocalIP(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("IsLocalIP: expected TEST-NET address not to be local")
+	}
+}
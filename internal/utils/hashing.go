@@ -2,65 +2,213 @@ package utils
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"math"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/zeebo/blake3"
+)
+
+// Hash algorithms files can be published with, as identified in the protocol
+const (
+	SHA1   = 0
+	SHA256 = 1
+	BLAKE3 = 2
 )
 
-func HashFile(file *os.File) ([20]byte, error) {
-	h := sha1.New()
+const DefaultHashAlgorithm = SHA1
+
+var hashFunctions = map[uint8]func() hash.Hash{
+	SHA1:   sha1.New,
+	SHA256: sha256.New,
+	BLAKE3: func() hash.Hash { return blake3.New() },
+}
+
+var hashAlgorithmNames = map[uint8]string{
+	SHA1:   "sha1",
+	SHA256: "sha256",
+	BLAKE3: "blake3",
+}
+
+// Returns a constructor of the hash function identified by the given algorithm
+func HashFunction(algorithm uint8) (func() hash.Hash, error) {
+	newHash, ok := hashFunctions[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm: %d", algorithm)
+	}
+
+	return newHash, nil
+}
+
+// Returns the size, in bytes, of the digests of the given algorithm, or 0 if it is not supported
+func HashSize(algorithm uint8) int {
+	newHash, ok := hashFunctions[algorithm]
+	if !ok {
+		return 0
+	}
+
+	return newHash().Size()
+}
+
+func HashAlgorithmName(algorithm uint8) string {
+	name, ok := hashAlgorithmNames[algorithm]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", algorithm)
+	}
+
+	return name
+}
+
+func ParseHashAlgorithm(name string) (uint8, error) {
+	for algorithm, algorithmName := range hashAlgorithmNames {
+		if strings.EqualFold(name, algorithmName) {
+			return algorithm, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported hash algorithm: %s", name)
+}
+
+func HashFile(file *os.File, algorithm uint8) ([]byte, error) {
+	newHash, err := HashFunction(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	h := newHash()
 	if _, err := io.Copy(h, file); err != nil {
-		return [20]byte{}, fmt.Errorf("error copying file: %v", err)
+		return nil, fmt.Errorf("error copying file: %v", err)
 	}
 
-	hash := h.Sum(nil)
-	var hashArr [20]byte
-	copy(hashArr[:], hash[:20])
+	return h.Sum(nil), nil
+}
+
+func HashFileChunks(file *os.File, algorithm uint8, dest *[][]byte) (uint64, error) {
+	_, chunkHashes, fileSize, err := HashFileAndChunks(file, algorithm)
+	if err != nil {
+		return 0, err
+	}
 
-	return hashArr, nil
+	*dest = chunkHashes
+
+	return fileSize, nil
 }
 
-func HashFileChunks(file *os.File, dest *[][20]byte) (uint64, error) {
-	_, err := file.Seek(0, 0)
+// Hashes the whole file and each of its chunks in a single sequential read.
+// At most 2 chunks per core are kept in memory, and chunks are hashed in parallel across cores
+func HashFileAndChunks(file *os.File, algorithm uint8) ([]byte, [][]byte, uint64, error) {
+	stats, err := file.Stat()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("error getting file stats: %v", err)
+	}
+
+	fileSize := uint64(stats.Size())
+
+	_, err = file.Seek(0, 0)
 	if err != nil {
-		return 0, fmt.Errorf("error seeking file: %v", err)
+		return nil, nil, 0, fmt.Errorf("error seeking file: %v", err)
 	}
 
-	content, err := io.ReadAll(file)
+	fileHash, chunkHashes, err := HashReaderAndChunks(file, fileSize, algorithm)
 	if err != nil {
-		return 0, fmt.Errorf("error reading file content: %v", err)
+		return nil, nil, 0, err
 	}
 
 	_, err = file.Seek(0, 0)
 	if err != nil {
-		return 0, fmt.Errorf("error seeking file: %v", err)
+		return nil, nil, 0, fmt.Errorf("error seeking file: %v", err)
+	}
+
+	return fileHash, chunkHashes, fileSize, nil
+}
+
+// Same as HashFileAndChunks, for content of the given size read from anywhere
+func HashReaderAndChunks(reader io.Reader, fileSize uint64, algorithm uint8) ([]byte, [][]byte, error) {
+	newHash, err := HashFunction(algorithm)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	fileSize := uint64(len(content))
 	if fileSize == 0 {
-		return 0, fmt.Errorf("file is empty")
+		return nil, nil, fmt.Errorf("file is empty")
 	}
 
 	chunkSize := ChunkSize(fileSize)
-	numChunks := uint64(math.Ceil(float64(fileSize) / float64(chunkSize)))
+	numChunks := NumberOfChunks(fileSize)
+	chunkHashes := make([][]byte, numChunks)
+
+	type job struct {
+		index  uint64
+		buffer []byte
+		length int
+	}
+
+	workers := runtime.NumCPU()
+	buffers := make(chan []byte, 2*workers)
+	for i := 0; i < cap(buffers); i++ {
+		buffers <- make([]byte, chunkSize)
+	}
 
-	chunkHashes := make([][20]byte, numChunks)
+	jobs := make(chan job, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := newHash()
+			for j := range jobs {
+				h.Reset()
+				h.Write(j.buffer[:j.length])
+				chunkHashes[j.index] = h.Sum(nil)
+				buffers <- j.buffer
+			}
+		}()
+	}
+
+	fileHasher := newHash()
 	for i := uint64(0); i < numChunks; i++ {
+		buffer := <-buffers
+		length := chunkSize
 		if i == numChunks-1 {
-			chunkHashes[i] = sha1.Sum(content[i*chunkSize:])
-		} else {
-			chunkHashes[i] = sha1.Sum(content[i*chunkSize : (i+1)*chunkSize])
+			length = fileSize - i*chunkSize
+		}
+
+		_, err = io.ReadFull(reader, buffer[:length])
+		if err != nil {
+			break
 		}
+
+		fileHasher.Write(buffer[:length])
+		jobs <- job{i, buffer, int(length)}
 	}
 
-	*dest = chunkHashes
+	close(jobs)
+	wg.Wait()
 
-	return fileSize, nil
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading file content: %v", err)
+	}
+
+	return fileHasher.Sum(nil), chunkHashes, nil
 }
 
-func HashChunk(chunk []byte) [20]byte {
-	return sha1.Sum(chunk)
+// Returns nil if the algorithm is not supported, which never matches a valid digest
+func HashChunk(algorithm uint8, chunk []byte) []byte {
+	newHash, err := HashFunction(algorithm)
+	if err != nil {
+		return nil
+	}
+
+	h := newHash()
+	h.Write(chunk)
+
+	return h.Sum(nil)
 }
 
 // FileSize -> bytes
@@ -72,3 +220,7 @@ func ChunkSize(fileSize uint64) uint64 {
 	// Calculate the chunk size using the provided equation
 	return uint64(math.Ceil(float64(fileSize)/(float64(chunkCountMultiplier)*float64(chunkBlockSize)))) * chunkBlockSize
 }
+
+func NumberOfChunks(fileSize uint64) uint64 {
+	return uint64(math.Ceil(float64(fileSize) / float64(ChunkSize(fileSize))))
+}
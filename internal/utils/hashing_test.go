@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
 	"io"
 	"os"
 	"testing"
@@ -33,13 +35,13 @@ func TestHashFile(t *testing.T) {
 	expectedHash := sha1.Sum([]byte(testContent))
 
 	// Call the HashFile function with the temporary file
-	actualHash, err := HashFile(tempFile)
+	actualHash, err := HashFile(tempFile, SHA1)
 	if err != nil {
 		t.Fatalf("Error hashing file: %v", err)
 	}
 
 	// Compare the expected and actual hash values
-	if expectedHash != actualHash {
+	if !bytes.Equal(expectedHash[:], actualHash) {
 		t.Errorf("Expected hash: %x, but got: %x", expectedHash, actualHash)
 	}
 }
@@ -62,14 +64,92 @@ func TestHashFileChunks(t *testing.T) {
 		}
 	}
 
-	var actualChunkHashes [][20]byte
+	var actualChunkHashes [][]byte
 	// Call the HashFileChunks function with the temporary file
-	_, err = HashFileChunks(tempFile, &actualChunkHashes)
+	_, err = HashFileChunks(tempFile, SHA1, &actualChunkHashes)
 	if err != nil {
 		t.Fatalf("Error hashing file chunks: %v", err)
 	}
 }
 
+func TestHashFileAndChunks(t *testing.T) {
+	// Create a temporary file for testing
+	tempFile, err := os.CreateTemp("", "testfile")
+	if err != nil {
+		t.Fatalf("Error creating temporary file: %v", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	// Write enough content for the file to span multiple chunks, the last one being partial
+	content := make([]byte, 40000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	_, err = tempFile.Write(content)
+	if err != nil {
+		t.Fatalf("Error writing to temporary file: %v", err)
+	}
+
+	fileHash, chunkHashes, fileSize, err := HashFileAndChunks(tempFile, SHA1)
+	if err != nil {
+		t.Fatalf("Error hashing file: %v", err)
+	}
+
+	if fileSize != uint64(len(content)) {
+		t.Errorf("Expected file size: %d, but got: %d", len(content), fileSize)
+	}
+
+	if expectedHash := sha1.Sum(content); !bytes.Equal(fileHash, expectedHash[:]) {
+		t.Errorf("Expected hash: %x, but got: %x", expectedHash, fileHash)
+	}
+
+	chunkSize := int(ChunkSize(uint64(len(content))))
+	expectedChunkHashes := [][20]byte{
+		sha1.Sum(content[:chunkSize]),
+		sha1.Sum(content[chunkSize : 2*chunkSize]),
+		sha1.Sum(content[2*chunkSize:]),
+	}
+
+	if len(chunkHashes) != len(expectedChunkHashes) {
+		t.Fatalf("Expected %d chunk hashes, but got: %d", len(expectedChunkHashes), len(chunkHashes))
+	}
+
+	for i := range expectedChunkHashes {
+		if !bytes.Equal(chunkHashes[i], expectedChunkHashes[i][:]) {
+			t.Errorf("Chunk %d: expected hash: %x, but got: %x", i, expectedChunkHashes[i], chunkHashes[i])
+		}
+	}
+}
+
+func TestHashAlgorithms(t *testing.T) {
+	chunk := []byte("Hello, this is a test chunk content.")
+
+	sha256Hash := sha256.Sum256(chunk)
+	if !bytes.Equal(HashChunk(SHA256, chunk), sha256Hash[:]) {
+		t.Errorf("Expected SHA-256 hash: %x, but got: %x", sha256Hash, HashChunk(SHA256, chunk))
+	}
+
+	for _, algorithm := range []uint8{SHA1, SHA256, BLAKE3} {
+		parsed, err := ParseHashAlgorithm(HashAlgorithmName(algorithm))
+		if err != nil || parsed != algorithm {
+			t.Errorf("Expected %s to parse back to %d, got %d (%v)", HashAlgorithmName(algorithm), algorithm, parsed, err)
+		}
+
+		if size := len(HashChunk(algorithm, chunk)); size != HashSize(algorithm) {
+			t.Errorf("Algorithm %s: expected digest of %d bytes, got %d", HashAlgorithmName(algorithm), HashSize(algorithm), size)
+		}
+	}
+
+	if HashChunk(42, chunk) != nil {
+		t.Errorf("Expected unsupported algorithm to produce no digest")
+	}
+
+	if _, err := ParseHashAlgorithm("md5"); err == nil {
+		t.Errorf("Expected md5 to be rejected")
+	}
+}
+
 func TestChunkSize(t *testing.T) {
 	// Test cases with different file sizes in kilobytes
 	testCases := []struct {
@@ -0,0 +1,190 @@
+package watcher
+
+import (
+	"PessiTorrent/internal/ticker"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Created  = 0 // A new file appeared in a watched directory
+	Modified = 1 // A file changed its size or modification time
+	Removed  = 2 // A file disappeared from a watched directory
+)
+
+type Event struct {
+	Type uint8
+	Path string
+}
+
+type EventHandler func(event Event)
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watcher polls directories, recursively, for files being created, modified or removed.
+// A file is only reported once it stays the same for a whole interval, so files still being
+// written to are not reported halfway through
+type Watcher struct {
+	directories map[string]struct{}
+	handle      EventHandler
+
+	observed map[string]fileState // State of every file on the last scan
+	reported map[string]fileState // State of every file as last reported to the handler
+
+	tck ticker.Ticker
+	mu  sync.Mutex
+}
+
+func NewWatcher(interval time.Duration, handle EventHandler) *Watcher {
+	w := &Watcher{
+		directories: make(map[string]struct{}),
+		handle:      handle,
+		observed:    make(map[string]fileState),
+		reported:    make(map[string]fileState),
+	}
+	w.tck = ticker.NewTicker(interval, w.Scan)
+
+	return w
+}
+
+func (w *Watcher) Start() {
+	w.tck.Start()
+}
+
+func (w *Watcher) Stop() {
+	w.tck.Stop()
+}
+
+// Starts watching the given directory. Files already in it are reported as created
+func (w *Watcher) Add(directory string) error {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.directories[directory] = struct{}{}
+
+	return nil
+}
+
+// Stops watching the given directory, without reporting its files as removed
+func (w *Watcher) Remove(directory string) error {
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.directories, directory)
+	for path := range w.reported {
+		if isInDirectory(path, directory) {
+			delete(w.reported, path)
+			delete(w.observed, path)
+		}
+	}
+
+	return nil
+}
+
+// Forgets the reported state of the given file, so the next scan reports it as created again.
+// Used by handlers that could not act on an event yet
+func (w *Watcher) Forget(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.reported, path)
+}
+
+func (w *Watcher) Directories() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	directories := make([]string, 0, len(w.directories))
+	for directory := range w.directories {
+		directories = append(directories, directory)
+	}
+
+	return directories
+}
+
+// Walks every watched directory once, reporting the changes since the previous scan
+func (w *Watcher) Scan() {
+	w.mu.Lock()
+
+	current := make(map[string]fileState)
+	for directory := range w.directories {
+		_ = filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil // Skip whatever can not be read
+			}
+
+			if strings.HasPrefix(d.Name(), ".") && path != directory {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil // Skip hidden files
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			current[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+	}
+
+	events := make([]Event, 0)
+	for path, state := range current {
+		if observed, ok := w.observed[path]; !ok || observed != state {
+			continue // Still being written to
+		}
+
+		reported, ok := w.reported[path]
+		switch {
+		case !ok:
+			events = append(events, Event{Type: Created, Path: path})
+		case reported != state:
+			events = append(events, Event{Type: Modified, Path: path})
+		default:
+			continue
+		}
+		w.reported[path] = state
+	}
+
+	for path := range w.reported {
+		if _, ok := current[path]; !ok {
+			events = append(events, Event{Type: Removed, Path: path})
+			delete(w.reported, path)
+		}
+	}
+
+	w.observed = current
+	w.mu.Unlock()
+
+	// Handled without the lock, so the handler can add or remove directories
+	for _, event := range events {
+		w.handle(event)
+	}
+}
+
+func isInDirectory(path string, directory string) bool {
+	return strings.HasPrefix(path, directory+string(os.PathSeparator))
+}
@@ -0,0 +1,80 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherScan(t *testing.T) {
+	directory := t.TempDir()
+
+	var events []Event
+	w := NewWatcher(time.Hour, func(event Event) {
+		events = append(events, event)
+	})
+
+	path := filepath.Join(directory, "file.txt")
+	if err := os.WriteFile(path, []byte("Hello"), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(directory, ".hidden"), []byte("Hidden"), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	if err := w.Add(directory); err != nil {
+		t.Fatalf("Error watching directory: %v", err)
+	}
+
+	// Files are only reported once they stayed the same for a whole scan
+	w.Scan()
+	if len(events) != 0 {
+		t.Fatalf("Expected no events on the first scan, got %v", events)
+	}
+
+	w.Scan()
+	expectEvents(t, events, Event{Type: Created, Path: path})
+	events = nil
+
+	w.Scan()
+	expectEvents(t, events)
+
+	// A forgotten file is reported as created again
+	w.Forget(path)
+	w.Scan()
+	expectEvents(t, events, Event{Type: Created, Path: path})
+	events = nil
+
+	if err := os.WriteFile(path, []byte("Hello, world"), 0644); err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	w.Scan()
+	expectEvents(t, events)
+
+	w.Scan()
+	expectEvents(t, events, Event{Type: Modified, Path: path})
+	events = nil
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Error removing file: %v", err)
+	}
+
+	w.Scan()
+	expectEvents(t, events, Event{Type: Removed, Path: path})
+}
+
+func expectEvents(t *testing.T, events []Event, expected ...Event) {
+	t.Helper()
+
+	if len(events) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected event %v, got %v", expected[i], events[i])
+		}
+	}
+}